@@ -15,6 +15,10 @@ type Config struct {
 	DSTInstallDir string
 	ClusterDir    string
 	BackupDir     string
+	// DataDir keeps the manager's own state (audit logs etc.)
+	DataDir string
+	// InstallsDir keeps earlier server builds next to DSTInstallDir
+	InstallsDir string
 }
 
 var (
@@ -35,13 +39,15 @@ func NewConfig() *Config {
 			DSTInstallDir: filepath.Join(home, "dst"),
 			ClusterDir:    filepath.Join(home, ".klei", "DoNotStarveTogether"),
 			BackupDir:     filepath.Join(home, "dst-backups"),
+			DataDir:       filepath.Join(home, ".dst-manager"),
+			InstallsDir:   filepath.Join(home, "dst-installs"),
 		}
 	})
 	return instance
 }
 
 func (c *Config) EnsureDirs() error {
-	dirs := []string{c.SteamCMDDir, c.DSTInstallDir, c.BackupDir}
+	dirs := []string{c.SteamCMDDir, c.DSTInstallDir, c.BackupDir, c.DataDir}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("failed to create directory %s: %v", dir, err)
@@ -5,6 +5,9 @@ import (
 	"dst-manager/utils"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,40 +26,86 @@ func main() {
 	mgr.Log("我是小花酱，会帮主人管理服务器哦~")
 	fmt.Println("========================================")
 
+	if err := mgr.Scheduler.Start(); err != nil {
+		mgr.Log("定时任务加载失败了喵: %v", err)
+	}
+	mgr.StartUpdateChecker()
+
 	for {
-		printMenu()
+		printMenu(mgr)
 		choice := utils.ReadInput("请输入选项数字喵: ")
 
 		switch choice {
 		case "1":
-			mgr.InstallDependencies()
+			printInstallInfo(mgr)
+			configureInstall(mgr)
+			installDependencies(mgr)
 			if err := mgr.InstallSteamCMD(); err != nil {
 				mgr.Log("SteamCMD 安装失败了喵: %v", err)
-				continue
+				// An offline server install does not need SteamCMD
+				if options, _ := mgr.LoadInstallOptions(); options.DSTArchive == "" {
+					continue
+				}
 			}
 			mgr.InstallDST()
 		case "2":
-			mgr.StartServer()
+			if cluster := mgr.SelectCluster("请选择要启动的存档喵:"); cluster != "" {
+				mgr.StartServer(cluster)
+			}
 		case "3":
-			mgr.StopServer()
+			if cluster := mgr.SelectRunningCluster("请选择要停止的存档喵:"); cluster != "" {
+				if grace := readGrace(); grace > 0 {
+					go mgr.StopServerAfter(cluster, grace)
+					mgr.Log("倒计时开始啦，可以在菜单 10 取消喵~")
+				} else {
+					mgr.StopServer(cluster)
+				}
+			}
 		case "4":
-			mgr.StopServer()
-			mgr.StartServer()
+			if cluster := mgr.SelectRunningCluster("请选择要重启的存档喵:"); cluster != "" {
+				if grace := readGrace(); grace > 0 {
+					go mgr.RestartServer(cluster, grace)
+					mgr.Log("倒计时开始啦，可以在菜单 10 取消喵~")
+				} else {
+					mgr.RestartServer(cluster, 0)
+				}
+			}
 		case "5":
-			// mgr.BackupCluster()
-			name := utils.ReadInput("请输入备份文件名喵: ")
+			cluster := mgr.SelectCluster("请选择要备份的存档喵:")
+			if cluster == "" {
+				continue
+			}
+			name := utils.ReadInput("请输入备份文件名喵 (直接回车自动生成): ")
 			if name == "" {
-				timestamp := time.Now().Format("20070831_162739")
-				name = fmt.Sprintf("backup_%s.tar.gz", timestamp)
+				name = manager.BackupFileName(cluster, time.Now())
 			}
-			mgr.BackupCluster(name)
+			mgr.BackupCluster(cluster, name)
 		case "6":
 			mgr.ListBackups()
 		case "7":
 			mgr.RestoreBackup()
 		case "8":
 			mgr.ManageClusters()
+		case "9":
+			mgr.ConsoleMenu()
+		case "10":
+			if cluster := mgr.SelectRunningCluster("请选择要取消倒计时的存档喵:"); cluster != "" {
+				if !mgr.CancelCountdown(cluster) {
+					mgr.Log("存档 %s 没有在倒计时喵~", cluster)
+				}
+			}
+		case "11":
+			checkUpdate(mgr)
+		case "12":
+			manageInstalls(mgr)
+		case "13":
+			runDoctor(mgr)
+		case "14":
+			manageMods(mgr)
 		case "0":
+			if !stopBeforeExit(mgr) {
+				continue
+			}
 			mgr.Log("好的喵，小花酱先退下了，主人要注意休息哦~")
 			os.Exit(0)
 		default:
@@ -68,8 +117,315 @@ func main() {
 	}
 }
 
-func printMenu() {
+// stopBeforeExit stops the running clusters once the user agrees; their
+// shards are children of the manager and would be left unwatched
+func stopBeforeExit(mgr *manager.Manager) bool {
+	running := mgr.RunningClusters()
+	if len(running) == 0 {
+		return true
+	}
+	mgr.Log("还有存档在运行喵: %s", strings.Join(running, ", "))
+	if utils.ReadInput("退出前会先保存并停止它们，确定要退出吗？(y/N): ") != "y" {
+		return false
+	}
+	var wg sync.WaitGroup
+	for _, cluster := range running {
+		wg.Add(1)
+		go func(cluster string) {
+			defer wg.Done()
+			mgr.StopServer(cluster)
+		}(cluster)
+	}
+	wg.Wait()
+	return true
+}
+
+// readGrace asks how long to warn players before a stop or restart
+func readGrace() time.Duration {
+	input := utils.ReadInput("提前多少秒通知玩家？(直接回车立即执行): ")
+	seconds, err := strconv.Atoi(input)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// printInstallInfo shows what the appmanifest says about the installed server
+func printInstallInfo(mgr *manager.Manager) {
+	info, err := mgr.InstallInfo()
+	switch {
+	case err != nil:
+		mgr.Log("读取安装信息失败了喵: %v", err)
+	case !info.Installed:
+		mgr.Log("还没有安装饥荒联机版服务端喵 (%s)", info.InstallDir)
+	default:
+		mgr.Log("已安装版本 %s，分支 %s，占用 %d MB", info.BuildID, info.Branch, info.SizeOnDisk>>20)
+		if !info.LastUpdated.IsZero() {
+			mgr.Log("上次更新: %s", info.LastUpdated.Format("2006-01-02 15:04"))
+		}
+		if !info.FullyInstalled() {
+			mgr.Log("注意：安装没有完成喵，状态: %s", strings.Join(info.State, ", "))
+		}
+	}
+}
+
+// configureInstall lets the user change the branch and validate option
+// before installing
+func configureInstall(mgr *manager.Manager) {
+	options, err := mgr.LoadInstallOptions()
+	if err != nil {
+		mgr.Log("读取安装选项失败了喵，使用默认选项: %v", err)
+	}
+	mgr.Log("当前安装选项: 分支 %s，校验文件 %v", options.BranchName(), options.Validate)
+	if options.DSTArchive != "" {
+		mgr.Log("离线安装: 服务端 %s", options.DSTArchive)
+	}
+	if utils.ReadInput("要修改安装选项吗？(y/N): ") != "y" {
+		return
+	}
+
+	if branch := utils.ReadInput("分支名 (public、updatebeta 等，直接回车保持不变): "); branch != "" {
+		if branch != options.BranchName() {
+			options.BetaPassword = ""
+		}
+		options.Branch = branch
+	}
+	if options.BranchName() != "public" {
+		if password := utils.ReadInput("测试分支密码 (没有就直接回车): "); password != "" {
+			options.BetaPassword = password
+		}
+	}
+	options.Validate = utils.ReadInput("安装后校验全部文件吗？较慢但能修复损坏的文件 (Y/n): ") != "n"
+
+	// Hosts without access to Steam install from files copied over
+	if utils.ReadInput("使用本地安装包离线安装吗？(y/N): ") == "y" {
+		options.SteamCMDArchive = utils.ReadInput("SteamCMD 安装包路径 (steamcmd_linux.tar.gz，已安装过就直接回车): ")
+		options.DSTArchive = utils.ReadInput("服务端安装包或目录路径 (.tar.gz/.tar 或目录): ")
+	} else {
+		options.SteamCMDArchive, options.DSTArchive = "", ""
+	}
+
+	if err := mgr.SaveInstallOptions(options); err != nil {
+		mgr.Log("保存安装选项失败了喵: %v", err)
+	}
+}
+
+// installDependencies checks the system packages and asks how to install
+// the missing ones
+func installDependencies(mgr *manager.Manager) {
+	report, err := mgr.CheckDependencies()
+	if err != nil {
+		mgr.Log("检查系统依赖失败了喵: %v", err)
+		return
+	}
+	if len(report.Missing) == 0 {
+		mgr.Log("系统依赖都已经安装好啦！")
+		return
+	}
+	mgr.Log("缺少 %d 个系统依赖: %s", len(report.Missing), strings.Join(report.Missing, " "))
+	switch utils.ReadInput("y 安装 / d 只显示要执行的命令 / r 只列出缺少的依赖，不调用 sudo / n 跳过 (Y/d/r/n): ") {
+	case "n":
+	case "d":
+		mgr.InstallDependenciesWith(manager.DependencyOptions{DryRun: true})
+	case "r":
+		mgr.InstallDependenciesWith(manager.DependencyOptions{Rootless: true})
+	default:
+		mgr.InstallDependencies()
+	}
+}
+
+// checkUpdate compares the installed build with Steam and offers to update
+func checkUpdate(mgr *manager.Manager) {
+	mgr.Log("正在向 Steam 查询最新版本，请稍候喵...")
+	status, err := mgr.CheckForUpdate()
+	if err != nil {
+		mgr.Log("检查更新失败了喵: %v", err)
+		return
+	}
+	if status.NotInstalled {
+		mgr.Log("还没有通过 SteamCMD 安装服务端喵，最新版本为 %s，请在菜单 1 安装~", status.LatestBuild)
+		return
+	}
+	if !status.Available {
+		mgr.Log("当前已是最新版本 %s 喵~", status.InstalledBuild)
+		return
+	}
+	mgr.Log("发现新版本 %s (当前 %s)！", status.LatestBuild, status.InstalledBuild)
+	if utils.ReadInput("现在更新吗？运行中的存档会在倒计时后停止，更新完再启动 (y/N): ") != "y" {
+		return
+	}
+	go func() {
+		if err := mgr.UpdateAndRestart("菜单手动更新"); err != nil {
+			mgr.Log("更新失败了喵: %v", err)
+		}
+	}()
+}
+
+// manageInstalls lists the kept builds and pins or rolls back a cluster
+func manageInstalls(mgr *manager.Manager) {
+	installs, err := mgr.Installs()
+	if err != nil {
+		mgr.Log("读取已安装版本失败了喵: %v", err)
+		return
+	}
+	if len(installs) == 0 {
+		mgr.Log("还没有安装服务端喵~")
+		return
+	}
+	fmt.Println("已安装的版本:")
+	for i, install := range installs {
+		name := install.ID
+		if install.Current {
+			name = "当前版本"
+		}
+		pinned := ""
+		if len(install.PinnedBy) > 0 {
+			pinned = " 使用中: " + strings.Join(install.PinnedBy, ", ")
+		}
+		fmt.Printf("  %d. %s (build %s, %s 分支)%s\n", i+1, name, install.BuildID, install.Branch, pinned)
+	}
+
+	cluster := mgr.SelectCluster("请选择要切换版本的存档喵:")
+	if cluster == "" {
+		return
+	}
+	input := utils.ReadInput("输入版本序号固定版本，输入 r 回退到上一个版本 (直接回车取消): ")
+	switch {
+	case input == "":
+		return
+	case input == "r":
+		grace := time.Duration(0)
+		if mgr.IsRunning(cluster) {
+			grace = readGrace()
+		}
+		if grace > 0 {
+			go func() {
+				if _, err := mgr.RollbackCluster(cluster, grace); err != nil {
+					mgr.Log("回退失败了喵: %v", err)
+				}
+			}()
+			mgr.Log("倒计时开始啦，可以在菜单 10 取消喵~")
+		} else if _, err := mgr.RollbackCluster(cluster, 0); err != nil {
+			mgr.Log("回退失败了喵: %v", err)
+		}
+	default:
+		index, err := strconv.Atoi(input)
+		if err != nil || index < 1 || index > len(installs) {
+			mgr.Log("没有这个版本喵~")
+			return
+		}
+		if err := mgr.PinInstall(cluster, installs[index-1].ID); err != nil {
+			mgr.Log("固定版本失败了喵: %v", err)
+		}
+	}
+}
+
+// runDoctor prints the diagnostics report with a fix under each problem
+func runDoctor(mgr *manager.Manager) {
+	mgr.Log("正在检查运行环境喵...")
+	report := mgr.Doctor()
+	marks := map[manager.DoctorStatus]string{
+		manager.DoctorPass: "✓",
+		manager.DoctorWarn: "!",
+		manager.DoctorFail: "✗",
+	}
+	category := ""
+	for _, check := range report.Checks {
+		if check.Category != category {
+			category = check.Category
+			fmt.Printf("[%s]\n", category)
+		}
+		fmt.Printf("  %s %s: %s\n", marks[check.Status], check.Name, check.Detail)
+		if check.Fix != "" {
+			fmt.Printf("      → %s\n", check.Fix)
+		}
+	}
+	fmt.Printf("通过 %d 项，警告 %d 项，失败 %d 项\n", report.Pass, report.Warn, report.Fail)
+	if report.Fail == 0 && report.Warn == 0 {
+		mgr.Log("环境一切正常，可以放心开服喵~")
+	}
+}
+
+// manageMods lists the Workshop mods and adds or updates them
+func manageMods(mgr *manager.Manager) {
+	mods, err := mgr.Mods()
+	if err != nil {
+		mgr.Log("读取模组失败了喵: %v", err)
+		return
+	}
+	states := map[manager.ModState]string{
+		manager.ModInstalled: "已安装",
+		manager.ModMissing:   "未下载",
+		manager.ModFailed:    "更新失败",
+		manager.ModUpdating:  "更新中",
+	}
+	if len(mods) == 0 {
+		fmt.Println("还没有模组喵~")
+	}
+	for _, mod := range mods {
+		name := mod.Name
+		if name == "" {
+			name = "(未知)"
+		}
+		line := fmt.Sprintf("  %s %s", mod.ID, name)
+		if mod.Version != "" {
+			line += " v" + mod.Version
+		}
+		line += " [" + states[mod.Status] + "]"
+		if len(mod.UsedBy) > 0 {
+			line += " 存档: " + strings.Join(mod.UsedBy, ", ")
+		}
+		fmt.Println(line)
+		if mod.Error != "" {
+			fmt.Printf("      → %s\n", mod.Error)
+		}
+	}
+
+	input := utils.ReadInput("a 添加模组，s 用 SteamCMD 更新，u 用服务端更新 (直接回车返回): ")
+	switch input {
+	case "a":
+		ids := strings.Fields(strings.ReplaceAll(utils.ReadInput("请输入模组 ID，多个用空格或逗号分隔: "), ",", " "))
+		if len(ids) == 0 {
+			return
+		}
+		if err := mgr.AddMods(ids); err != nil {
+			mgr.Log("添加模组失败了喵: %v", err)
+			return
+		}
+		if utils.ReadInput("现在就用 SteamCMD 下载吗？(y/n): ") == "y" {
+			mgr.UpdateMods(manager.ModUpdateOptions{Method: manager.ModMethodSteamCMD, IDs: ids})
+		}
+	case "s":
+		mgr.UpdateMods(manager.ModUpdateOptions{Method: manager.ModMethodSteamCMD})
+	case "u":
+		mgr.UpdateMods(manager.ModUpdateOptions{Method: manager.ModMethodServer})
+	}
+}
+
+func printMenu(mgr *manager.Manager) {
 	fmt.Println("\n============== 功能菜单 ==============")
+	if active := mgr.ActiveClusters(); len(active) > 0 {
+		for _, cluster := range active {
+			var shards []string
+			for _, status := range mgr.ClusterStatus(cluster) {
+				if status.UptimeSeconds > 0 {
+					uptime := time.Duration(status.UptimeSeconds) * time.Second
+					if current := mgr.ShardResources(cluster, status.Shard).Current; current != nil {
+						shards = append(shards, fmt.Sprintf("%s[%s %v %dMB %.0f%%]", status.Shard, status.State, uptime,
+							current.RSSBytes>>20, current.CPUPercent))
+					} else {
+						shards = append(shards, fmt.Sprintf("%s[%s %v]", status.Shard, status.State, uptime))
+					}
+				} else {
+					shards = append(shards, fmt.Sprintf("%s[%s]", status.Shard, status.State))
+				}
+			}
+			fmt.Printf("  %s: %s\n", cluster, strings.Join(shards, " "))
+		}
+	} else {
+		fmt.Println("  运行中: 无")
+	}
+	fmt.Println("--------------------------------------")
 	fmt.Println("  1. 安装/更新环境")
 	fmt.Println("  2. 启动服务器")
 	fmt.Println("  3. 停止服务器")
@@ -78,6 +434,12 @@ func printMenu() {
 	fmt.Println("  6. 查看备份列表")
 	fmt.Println("  7. 恢复存档")
 	fmt.Println("  8. 存档管理")
+	fmt.Println("  9. 控制台命令")
+	fmt.Println(" 10. 取消停止/重启倒计时")
+	fmt.Println(" 11. 检查游戏更新")
+	fmt.Println(" 12. 版本固定/回退")
+	fmt.Println(" 13. 环境诊断")
+	fmt.Println(" 14. 模组管理")
 	fmt.Println("  0. 退出")
 	fmt.Println("======================================")
 }
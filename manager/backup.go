@@ -7,39 +7,57 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 // BackupCluster creates a backup of the cluster
 // 备份存档
-func (m *Manager) BackupCluster(filename string) {
-	m.Log("开始备份存档，请稍候喵...")
+func (m *Manager) BackupCluster(cluster, filename string) (err error) {
+	m.Log("开始备份存档 %s，请稍候喵...", cluster)
+	start := time.Now()
+	defer func() {
+		m.instruments.backups.Inc(cluster, outcomeLabel(err))
+		if err == nil {
+			m.instruments.backupDuration.Observe(time.Since(start).Seconds(), cluster)
+		}
+	}()
 
 	// Create backup dir
 	if err := os.MkdirAll(m.Config.BackupDir, 0755); err != nil {
 		m.Log("创建备份目录失败了喵: %v", err)
-		return
+		return err
 	}
 
 	backupPath := filepath.Join(m.Config.BackupDir, filename)
 
-	// Target: ~/.klei/DoNotStarveTogether/Cluster_1
-	// We backup the whole Cluster_1 folder
-	clusterPath := filepath.Join(m.Config.ClusterDir, "Cluster_1")
+	// Target: ~/.klei/DoNotStarveTogether/<cluster>
+	// We backup the whole cluster folder
+	clusterPath := filepath.Join(m.Config.ClusterDir, cluster)
 
 	if _, err := os.Stat(clusterPath); os.IsNotExist(err) {
 		m.Log("找不到存档目录喵: %s", clusterPath)
-		return
+		return fmt.Errorf("找不到存档目录: %s", clusterPath)
 	}
 
-	// tar -czf <backup> -C <parent> Cluster_1
+	// tar -czf <backup> -C <parent> <cluster>
 	parentDir := filepath.Dir(clusterPath)
-	err := utils.RunCommand("tar", "-czf", backupPath, "-C", parentDir, "Cluster_1")
+	err = utils.RunCommand("tar", "-czf", backupPath, "-C", parentDir, cluster)
 	if err != nil {
 		m.Log("备份失败了喵: %v", err)
-		return
+		return err
+	}
+	if info, err := os.Stat(backupPath); err == nil {
+		m.instruments.backupSize.Set(float64(info.Size()), cluster)
 	}
 
 	m.Log("存档备份成功！文件保存在: %s", filename)
+	return nil
+}
+
+// BackupFileName returns the default backup file name for a cluster
+// 生成默认的备份文件名
+func BackupFileName(cluster string, t time.Time) string {
+	return fmt.Sprintf("backup_%s_%s.tar.gz", cluster, t.Format("20060102_150405"))
 }
 
 // ListBackups lists available backups and returns them
@@ -110,8 +128,8 @@ func (m *Manager) RestoreBackup() {
 		return
 	}
 
-	if m.IsRunning() {
-		m.Log("服务器正在运行喵！请先停止服务器再恢复存档~")
+	if m.IsRunning(targetCluster) {
+		m.Log("存档 %s 正在运行喵！请先停止服务器再恢复存档~", targetCluster)
 		return
 	}
 
@@ -0,0 +1,192 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// installOptionsFile keeps the branch and validate choice in DataDir
+	installOptionsFile = "install.json"
+	// installedBuildFile records the build the last install produced
+	installedBuildFile = "installed_build.json"
+	// publicBranch is Steam's default branch
+	publicBranch = "public"
+)
+
+// InstallOptions selects what InstallDST installs
+// 安装服务端时使用的分支和校验选项
+type InstallOptions struct {
+	// Branch is a Steam beta branch such as updatebeta; empty means public
+	Branch       string `json:"branch"`
+	BetaPassword string `json:"beta_password,omitempty"`
+	// Validate checks every file after the download; slower but repairs
+	// damaged installs
+	Validate bool `json:"validate"`
+	// KeepVersions is how many earlier builds to keep for pinning and
+	// rollback; 0 keeps none
+	KeepVersions int `json:"keep_versions"`
+	// SteamCMDURL is where the SteamCMD bootstrap is downloaded from;
+	// empty means Valve's CDN
+	SteamCMDURL string `json:"steamcmd_url,omitempty"`
+	// SteamCMDSHA256 is the expected checksum of the bootstrap. When empty
+	// the checksum of the first download is pinned here.
+	SteamCMDSHA256 string `json:"steamcmd_sha256,omitempty"`
+	// HTTPProxy is used for downloads; empty uses the environment
+	HTTPProxy string `json:"http_proxy,omitempty"`
+	// SteamCMDArchive and DSTArchive install from local files instead of
+	// the network. DSTArchive may also be a directory with a server tree.
+	SteamCMDArchive string `json:"steamcmd_archive,omitempty"`
+	DSTArchive      string `json:"dst_archive,omitempty"`
+}
+
+// BranchName returns the branch, with public for an empty one
+// 返回分支名，为空时是 public
+func (o InstallOptions) BranchName() string {
+	if o.Branch == "" {
+		return publicBranch
+	}
+	return o.Branch
+}
+
+// steamArgs returns the +app_update arguments for these options
+func (o InstallOptions) steamArgs() []string {
+	// Always name the branch, otherwise SteamCMD keeps whatever branch
+	// was chosen before
+	args := []string{"+app_update", dstAppID, "-beta", o.BranchName()}
+	if o.BetaPassword != "" {
+		args = append(args, "-betapassword", o.BetaPassword)
+	}
+	if o.Validate {
+		args = append(args, "validate")
+	}
+	return args
+}
+
+// InstalledBuild records what the last successful install produced
+// 最近一次成功安装的记录
+type InstalledBuild struct {
+	Branch      string    `json:"branch"`
+	BuildID     string    `json:"build_id"`
+	Validated   bool      `json:"validated"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// BranchMismatchError is returned when a cluster expects a different
+// branch than the one installed
+// 存档要求的分支与已安装的分支不一致
+type BranchMismatchError struct {
+	Cluster   string
+	Expected  string
+	Installed string
+}
+
+func (e *BranchMismatchError) Error() string {
+	return fmt.Sprintf("存档 %s 需要 %s 分支，但当前安装的是 %s 分支，请先切换分支并更新服务端",
+		e.Cluster, e.Expected, e.Installed)
+}
+
+// DefaultInstallOptions returns the options used when there is no file
+// 默认安装选项
+func DefaultInstallOptions() *InstallOptions {
+	return &InstallOptions{
+		Branch:       publicBranch,
+		Validate:     true,
+		KeepVersions: 2,
+	}
+}
+
+// LoadInstallOptions reads the install options, falling back to defaults
+// 读取安装选项，缺失时使用默认值
+func (m *Manager) LoadInstallOptions() (*InstallOptions, error) {
+	options := DefaultInstallOptions()
+
+	data, err := os.ReadFile(filepath.Join(m.Config.DataDir, installOptionsFile))
+	if os.IsNotExist(err) {
+		return options, nil
+	}
+	if err != nil {
+		return options, err
+	}
+	if err := json.Unmarshal(data, options); err != nil {
+		return DefaultInstallOptions(), fmt.Errorf("解析 %s 失败: %v", installOptionsFile, err)
+	}
+	return options, nil
+}
+
+// SaveInstallOptions writes the install options. The file holds the beta
+// password and maybe proxy credentials, so only the owner can read it.
+// 保存安装选项，文件中有测试分支密码，只允许本人读取
+func (m *Manager) SaveInstallOptions(options *InstallOptions) error {
+	options.Branch = strings.TrimSpace(options.Branch)
+	if strings.ContainsAny(options.Branch, " \t\"") {
+		return fmt.Errorf("无效的分支名: %q", options.Branch)
+	}
+	if options.HTTPProxy != "" {
+		if u, err := url.Parse(options.HTTPProxy); err != nil || u.Host == "" {
+			return fmt.Errorf("无效的代理地址: %q", options.HTTPProxy)
+		}
+	}
+	if err := os.MkdirAll(m.Config.DataDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(options, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.Config.DataDir, installOptionsFile), data, 0600)
+}
+
+// LastInstalledBuild returns the record of the last successful install
+// 返回最近一次成功安装的记录
+func (m *Manager) LastInstalledBuild() (*InstalledBuild, error) {
+	data, err := os.ReadFile(filepath.Join(m.Config.DataDir, installedBuildFile))
+	if err != nil {
+		return nil, err
+	}
+	var build InstalledBuild
+	if err := json.Unmarshal(data, &build); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", installedBuildFile, err)
+	}
+	return &build, nil
+}
+
+func (m *Manager) recordInstalledBuild(build InstalledBuild) error {
+	if err := os.MkdirAll(m.Config.DataDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(build, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.Config.DataDir, installedBuildFile), data, 0644)
+}
+
+// checkBranch fails when the cluster declares a branch other than the one
+// of the install it runs from
+// 检查存档要求的分支是否与已安装的一致
+func (m *Manager) checkBranch(cluster string, settings *ClusterSettings) error {
+	if settings.Branch == "" {
+		return nil
+	}
+	dir, err := m.clusterInstallDir(cluster)
+	if err != nil {
+		return err
+	}
+	info, err := readInstallInfo(dir)
+	if err != nil {
+		return fmt.Errorf("无法确认已安装的分支: %v", err)
+	}
+	if !info.Installed {
+		return fmt.Errorf("还没有安装服务端，无法确认分支")
+	}
+	if !strings.EqualFold(info.Branch, settings.Branch) {
+		return &BranchMismatchError{Cluster: cluster, Expected: settings.Branch, Installed: info.Branch}
+	}
+	return nil
+}
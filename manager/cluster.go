@@ -2,6 +2,7 @@ package manager
 
 import (
 	"dst-manager/utils"
+	"dst-manager/utils/clusterUtils"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -89,11 +90,15 @@ func (m *Manager) CreateCluster() {
 		return
 	}
 
+	withCaves := strings.ToLower(utils.ReadInput("要创建洞穴世界吗？(Y/n): ")) != "n"
+
 	// Create directories
 	dirs := []string{
 		clusterPath,
 		filepath.Join(clusterPath, "Master"),
-		filepath.Join(clusterPath, "Caves"),
+	}
+	if withCaves {
+		dirs = append(dirs, filepath.Join(clusterPath, "Caves"))
 	}
 
 	for _, dir := range dirs {
@@ -107,67 +112,16 @@ func (m *Manager) CreateCluster() {
 	os.WriteFile(filepath.Join(clusterPath, "cluster_token.txt"), []byte(token), 0644)
 
 	// Write default configs
-	m.writeDefaultConfigs(clusterPath)
+	m.writeDefaultConfigs(clusterPath, withCaves)
 
 	m.Log("存档 %s 创建成功啦！快去启动试试吧喵~", name)
 }
 
-// writeDefaultConfigs writes default .ini files
-func (m *Manager) writeDefaultConfigs(clusterPath string) {
-	// cluster.ini
-	clusterIni := `[GAMEPLAY]
-game_mode = survival
-max_players = 6
-pvp = false
-pause_when_empty = true
-
-[NETWORK]
-cluster_name = New DST Server
-cluster_description =  
-cluster_intention = cooperative
-
-[MISC]
-console_enabled = true
-`
-	os.WriteFile(filepath.Join(clusterPath, "cluster.ini"), []byte(clusterIni), 0644)
-
-	// Master/server.ini
-	masterIni := `[NETWORK]
-server_port = 10999
-
-[SHARD]
-is_master = true
-
-[STEAM]
-authentication_port = 8766
-master_server_port = 27016
-`
-	os.WriteFile(filepath.Join(clusterPath, "Master", "server.ini"), []byte(masterIni), 0644)
-
-	// Caves/server.ini
-	cavesIni := `[NETWORK]
-server_port = 10998
-
-[SHARD]
-is_master = false
-name = Caves
-
-[STEAM]
-authentication_port = 8765
-master_server_port = 27015
-
-[WORLD]
-id = 2
-`
-	os.WriteFile(filepath.Join(clusterPath, "Caves", "server.ini"), []byte(cavesIni), 0644)
-
-	// Create worldgenoverride.lua for Caves (essential for caves to work properly)
-	cavesOverride := `return {
-    override_enabled = true,
-    preset = "DST_CAVE",
-}
-`
-	os.WriteFile(filepath.Join(clusterPath, "Caves", "worldgenoverride.lua"), []byte(cavesOverride), 0644)
+// writeDefaultConfigs writes default .ini files, with a Caves shard if asked
+func (m *Manager) writeDefaultConfigs(clusterPath string, withCaves bool) {
+	if err := clusterUtils.WriteDefaultConfig(clusterPath, "", "", withCaves); err != nil {
+		m.Log("写入默认配置失败了喵: %v", err)
+	}
 }
 
 // DeleteCluster deletes a cluster
@@ -0,0 +1,236 @@
+package manager
+
+import (
+	"bufio"
+	"dst-manager/utils"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// consoleAuditFile keeps every console command sent through the manager
+	// 控制台指令审计日志
+	consoleAuditFile = "console_audit.log"
+
+	// consoleQuiet is how long the shard must stay silent before the
+	// command's output is considered complete, bounded by consoleWait
+	consoleQuiet = 500 * time.Millisecond
+	consoleWait  = 3 * time.Second
+
+	// systemOperator is the operator recorded for commands the manager
+	// sends by itself, such as the periodic player list check
+	systemOperator = "system"
+)
+
+var auditMu sync.Mutex
+
+// ConsoleResult is the output captured after a console command
+// 控制台指令的执行结果
+type ConsoleResult struct {
+	Cluster string    `json:"cluster"`
+	Shard   string    `json:"shard"`
+	Command string    `json:"command"`
+	Output  []string  `json:"output"`
+	Time    time.Time `json:"time"`
+}
+
+// AuditEntry is one line of the console audit trail
+// 审计日志条目
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Operator string    `json:"operator"`
+	Cluster  string    `json:"cluster"`
+	Shard    string    `json:"shard"`
+	Command  string    `json:"command"`
+	Output   []string  `json:"output,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// SendConsole runs a Lua command in a running shard's console
+// 向运行中的世界发送控制台指令
+func (m *Manager) SendConsole(cluster, shard, command string) (*ConsoleResult, error) {
+	return m.SendConsoleAs("local", cluster, shard, command)
+}
+
+// SendConsoleAs runs a console command on behalf of an operator and records
+// it with its output in the audit trail
+// 以指定操作者身份发送控制台指令，并记录到审计日志
+func (m *Manager) SendConsoleAs(operator, cluster, shard, command string) (*ConsoleResult, error) {
+	result, err := m.sendConsole(cluster, shard, command)
+	var output []string
+	if result != nil {
+		output = result.Output
+	}
+	m.audit(operator, cluster, shard, command, output, err)
+	return result, err
+}
+
+// audit records a command sent to a shard console, by an operator or by
+// the manager itself as systemOperator
+func (m *Manager) audit(operator, cluster, shard, command string, output []string, err error) {
+	entry := AuditEntry{
+		Time:     time.Now(),
+		Operator: operator,
+		Cluster:  cluster,
+		Shard:    shard,
+		Command:  command,
+		Output:   output,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if auditErr := m.appendAudit(entry); auditErr != nil {
+		m.Log("写入审计日志失败了喵: %v", auditErr)
+	}
+}
+
+func (m *Manager) sendConsole(cluster, shardName, command string) (*ConsoleResult, error) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return nil, fmt.Errorf("指令不能为空喵")
+	}
+	if strings.ContainsAny(command, "\r\n") {
+		return nil, fmt.Errorf("一次只能发送一行指令喵")
+	}
+
+	shard, ok := m.Supervisor.Get(cluster, shardName)
+	if !ok || !shard.Running() {
+		return nil, fmt.Errorf("%s/%s 没有在运行喵", cluster, shardName)
+	}
+
+	lines, unsubscribe := shard.Subscribe()
+	defer unsubscribe()
+
+	result := &ConsoleResult{
+		Cluster: cluster,
+		Shard:   shardName,
+		Command: command,
+		Output:  []string{},
+		Time:    time.Now(),
+	}
+	if err := shard.SendCommand(command); err != nil {
+		return nil, err
+	}
+
+	deadline := time.After(consoleWait)
+	quiet := time.NewTimer(consoleWait)
+	defer quiet.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return result, nil
+			}
+			result.Output = append(result.Output, line)
+			quiet.Reset(consoleQuiet)
+		case <-quiet.C:
+			return result, nil
+		case <-deadline:
+			return result, nil
+		}
+	}
+}
+
+func (m *Manager) appendAudit(entry AuditEntry) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if err := os.MkdirAll(m.Config.DataDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(m.Config.DataDir, consoleAuditFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(entry)
+}
+
+// ConsoleAudit returns the most recent audit entries of a cluster, newest
+// last. An empty cluster returns entries of every cluster.
+// 读取存档最近的审计记录（cluster 为空时返回全部）
+func (m *Manager) ConsoleAudit(cluster string, limit int) ([]AuditEntry, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	f, err := os.Open(filepath.Join(m.Config.DataDir, consoleAuditFile))
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if cluster != "" && entry.Cluster != cluster {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, scanner.Err()
+}
+
+// RunningShards returns the names of the live shards of a cluster
+// 返回存档中正在运行的世界
+func (m *Manager) RunningShards(cluster string) []string {
+	var shards []string
+	for _, shard := range m.Supervisor.Running() {
+		if shard.Cluster == cluster {
+			shards = append(shards, shard.Name)
+		}
+	}
+	return shards
+}
+
+// ConsoleMenu lets the user send console commands to a running shard
+// 控制台菜单
+func (m *Manager) ConsoleMenu() {
+	cluster := m.SelectRunningCluster("请选择要操作的存档喵:")
+	if cluster == "" {
+		return
+	}
+
+	shards := m.RunningShards(cluster)
+	m.Log("请选择要发送指令的世界喵:")
+	for i, name := range shards {
+		fmt.Printf("  [%d] %s\n", i+1, name)
+	}
+	input := utils.ReadInput("请输入编号 (输入 0 取消): ")
+	var index int
+	if _, err := fmt.Sscanf(input, "%d", &index); err != nil || index < 1 || index > len(shards) {
+		return
+	}
+	shard := shards[index-1]
+
+	m.Log("常用指令: c_announce(\"消息\"), c_save(), c_rollback(1), c_listallplayers()")
+	for {
+		command := utils.ReadInput(fmt.Sprintf("%s/%s> (直接回车返回) ", cluster, shard))
+		if command == "" {
+			return
+		}
+		result, err := m.SendConsole(cluster, shard, command)
+		if err != nil {
+			m.Log("指令发送失败了喵: %v", err)
+			continue
+		}
+		for _, line := range result.Output {
+			fmt.Printf("  | %s\n", line)
+		}
+	}
+}
@@ -5,115 +5,308 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"sync"
 	"time"
 )
 
-// StartServer starts the DST server in a screen session
-// 启动服务器
-func (m *Manager) StartServer() error {
-	m.Log("正在启动服务器，请稍候喵...")
+// StartServer starts the shards of a cluster under the supervisor
+// 启动指定存档的服务器
+func (m *Manager) StartServer(cluster string) error {
+	if cluster == "" {
+		return fmt.Errorf("请选择要启动的存档喵~")
+	}
+	if _, err := os.Stat(filepath.Join(m.Config.ClusterDir, cluster)); err != nil {
+		return fmt.Errorf("存档 %s 不存在喵", cluster)
+	}
+
+	m.Log("正在启动存档 %s，请稍候喵...", cluster)
+
+	if m.installing() && m.FollowsCurrentInstall(cluster) {
+		m.Log("游戏正在更新中，请等更新完成后再启动喵~")
+		return fmt.Errorf("游戏正在更新中，请等更新完成后再启动喵~")
+	}
 
 	// Check if already running
-	if m.IsRunning() {
-		m.Log("服务器已经在运行了喵！不要重复启动哦~")
-		return fmt.Errorf("服务器已经在运行了喵！不要重复启动哦~")
+	if m.IsRunning(cluster) {
+		m.Log("存档 %s 已经在运行了喵！不要重复启动哦~", cluster)
+		return fmt.Errorf("存档 %s 已经在运行了喵！不要重复启动哦~", cluster)
 	}
 
-	// Select Cluster
-	cluster := m.SelectCluster("请选择要启动的存档喵:")
-	if cluster == "" {
-		return fmt.Errorf("请选择要启动的存档喵~")
+	// Executable path, from the install the cluster is pinned to
+	if _, err := m.ServerBinary(cluster); err != nil {
+		m.Log("%v", err)
+		return err
+	}
+
+	shards, err := m.ListShards(cluster)
+	if err != nil {
+		m.Log("%v", err)
+		return err
+	}
+
+	settings, err := m.LoadClusterSettings(cluster)
+	if err != nil {
+		m.Log("读取 %s 的设置失败了喵，使用默认设置: %v", cluster, err)
 	}
-	m.Log("即将启动存档: %s", cluster)
+	if err := m.checkBranch(cluster, settings); err != nil {
+		m.Log("%v", err)
+		return err
+	}
+	timeout := time.Duration(settings.Startup.ReadyTimeoutSeconds) * time.Second
 
-	// Executable path
-	// 64-bit executable is standard now
-	binPath := filepath.Join(m.Config.DSTInstallDir, "bin64", "dontstarve_dedicated_server_nullrenderer_x64")
-	if _, err := os.Stat(binPath); os.IsNotExist(err) {
-		// Fallback to 32-bit
-		binPath = filepath.Join(m.Config.DSTInstallDir, "bin", "dontstarve_dedicated_server_nullrenderer")
+	// Master first, the other shards connect to it once it is ready
+	// 先启动主世界，等它就绪后再启动其他世界
+	master, err := m.startShard(cluster, shards[0].Name)
+	if err != nil {
+		return err
 	}
+	m.Log("正在等待 %s 就绪喵...", master.Name)
+	if err := awaitReady(master, timeout); err != nil {
+		m.Log("%s 启动失败了喵: %v", master.Name, err)
+		abortShard(master, err)
+		return err
+	}
+	m.states.ready(cluster, master.Name)
+	m.Log("%s 已就绪！", master.Name)
 
-	// We launch Master and Caves separately
-	// 启动 Master
-	m.startShard(binPath, cluster, "Master")
+	started := []*Shard{master}
+	for _, info := range shards[1:] {
+		shard, err := m.startShard(cluster, info.Name)
+		if err != nil {
+			m.abortStartup(started, err)
+			return err
+		}
+		started = append(started, shard)
+	}
 
-	// 启动 Caves
-	m.startShard(binPath, cluster, "Caves")
+	// Secondary shards load in parallel, wait for all of them
+	errs := make([]error, len(started))
+	var wg sync.WaitGroup
+	for i, shard := range started[1:] {
+		wg.Add(1)
+		go func(i int, shard *Shard) {
+			defer wg.Done()
+			errs[i] = awaitReady(shard, timeout)
+		}(i+1, shard)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			m.Log("%s 启动失败了喵: %v", started[i].Name, err)
+			m.abortStartup(started, err)
+			return err
+		}
+	}
 
-	m.Log("服务器启动指令已发送！可以用 screen -ls 查看后台进程喵~")
+	for _, shard := range started {
+		m.states.ready(cluster, shard.Name)
+		m.watch(shard)
+	}
+	m.Log("存档 %s 的所有世界都已就绪，小花酱会在后台看着它们喵~", cluster)
 	return nil
 }
 
-func (m *Manager) startShard(binPath, clusterName, shardName string) {
-	screenName := fmt.Sprintf("dst_%s", shardName)
-	cmd := fmt.Sprintf("cd %s && %s -console -cluster %s -shard %s",
-		filepath.Dir(binPath), binPath, clusterName, shardName)
-
-	// Use screen to run in background
-	// screen -dmS <name> <command>
-	err := utils.RunCommand("screen", "-dmS", screenName, "bash", "-c", cmd)
+// startShard spawns one shard process; callers decide when to watch it.
+// The binary comes from the cluster's pinned install.
+func (m *Manager) startShard(clusterName, shardName string) (*Shard, error) {
+	binPath, err := m.ServerBinary(clusterName)
 	if err != nil {
 		m.Log("启动 %s 失败了喵: %v", shardName, err)
-	} else {
-		m.Log("%s 世界启动成功！", shardName)
+		return nil, err
 	}
+	// The server must run from its bin directory to find its data files
+	shard, err := m.Supervisor.Start(ShardSpec{
+		Cluster: clusterName,
+		Name:    shardName,
+		Binary:  binPath,
+		Dir:     filepath.Dir(binPath),
+		Args:    []string{"-console", "-cluster", clusterName, "-shard", shardName},
+	})
+	if err != nil {
+		m.Log("启动 %s 失败了喵: %v", shardName, err)
+		return nil, err
+	}
+	m.Log("%s 世界进程已启动 (PID %d)", shardName, shard.PID())
+	m.instruments.shardStarts.Inc(clusterName, shardName)
+	m.track(shard)
+	return shard, nil
 }
 
-// StopServer stops the DST server
-// 停止服务器
-func (m *Manager) StopServer() {
-	m.Log("正在停止服务器，会保存存档喵...")
-
-	m.stopShard("Master")
-	m.stopShard("Caves")
-
-	m.Log("服务器已停止，休息一下吧主人~")
+// abortStartup takes down every shard started by a failed StartServer
+// 启动失败时结束已启动的世界
+func (m *Manager) abortStartup(shards []*Shard, cause error) {
+	var wg sync.WaitGroup
+	for _, shard := range shards {
+		wg.Add(1)
+		go func(shard *Shard) {
+			defer wg.Done()
+			abortShard(shard, cause)
+		}(shard)
+	}
+	wg.Wait()
 }
 
-func (m *Manager) StopMaster() {
-	m.Log("正在停止地面服务器，会保存存档喵...")
+// StopServer stops the shards of a cluster, waiting for each to save and
+// exit, and reports how every shard ended
+// 停止指定存档的服务器，等待每个世界保存并退出，返回各世界的停止结果
+func (m *Manager) StopServer(cluster string) []StopResult {
+	m.Log("正在停止存档 %s，会保存存档喵...", cluster)
+
+	settings, err := m.LoadClusterSettings(cluster)
+	if err != nil {
+		m.Log("读取 %s 的设置失败了喵，使用默认设置: %v", cluster, err)
+	}
 
-	m.stopShard("Master")
+	shards := m.stopTargets(cluster)
+	results := make([]StopResult, len(shards))
+	var wg sync.WaitGroup
+	for i, name := range shards {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = m.stopShard(cluster, name, settings.Shutdown)
+		}(i, name)
+	}
+	wg.Wait()
 
-	m.Log("地面服务器已停止，休息一下吧主人~")
+	m.Log("存档 %s 已停止，休息一下吧主人~", cluster)
+	return results
 }
 
-func (m *Manager) StopCaves() {
-	m.Log("正在停止洞穴服务器，会保存存档喵...")
+// StopShard stops a single shard of a cluster
+// 停止存档中的单个世界
+func (m *Manager) StopShard(cluster, shard string) StopResult {
+	m.Log("正在停止 %s/%s，会保存存档喵...", cluster, shard)
 
-	m.stopShard("Caves")
+	settings, _ := m.LoadClusterSettings(cluster)
+	result := m.stopShard(cluster, shard, settings.Shutdown)
 
-	m.Log("洞穴服务器已停止，休息一下吧主人~")
+	m.Log("%s/%s 已停止，休息一下吧主人~", cluster, shard)
+	return result
 }
 
-func (m *Manager) stopShard(shardName string) {
-	screenName := fmt.Sprintf("dst_%s", shardName)
-	// Send c_shutdown(true) to save and exit
-	// screen -S <name> -p 0 -X stuff "c_shutdown(true)\n"
-	cmd := "c_shutdown(true)\n"
+// stopTargets returns the shards found on disk plus any running shard of
+// the cluster whose folder has since disappeared
+// 需要停止的世界：磁盘上的世界加上目录已不存在但仍在运行的世界
+func (m *Manager) stopTargets(cluster string) []string {
+	var names []string
+	if shards, err := m.ListShards(cluster); err == nil {
+		for _, shard := range shards {
+			names = append(names, shard.Name)
+		}
+	}
+	for _, running := range m.RunningShards(cluster) {
+		found := false
+		for _, name := range names {
+			if name == running {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, running)
+		}
+	}
+	return names
+}
+
+func (m *Manager) stopShard(cluster, shardName string, policy ShutdownPolicy) StopResult {
+	result := StopResult{Cluster: cluster, Shard: shardName, Outcome: StopNotRunning}
 
-	// Check if screen exists first
-	out, _ := utils.RunCommandOutput("screen", "-ls")
-	if !strings.Contains(out, screenName) {
+	// A crashed shard waiting for its restart counts as stopped now. Mark
+	// it before cancelling, so a crash handler that has not armed yet
+	// sees the stop once it does.
+	shard, ok := m.Supervisor.Get(cluster, shardName)
+	if ok {
+		shard.RequestStop()
+	}
+	m.watchdog.cancel(shardKey(cluster, shardName))
+
+	if !ok || !shard.Running() {
+		m.states.set(cluster, shardName, StateStopped)
 		m.Log("%s 似乎没有在运行喵。", shardName)
-		return
+		return result
 	}
+	m.states.set(cluster, shardName, StateStopping)
+	start := time.Now()
 
+	// Subscribe before sending so the save message cannot be missed
+	lines, unsubscribe := shard.Subscribe()
+	defer unsubscribe()
+
+	// Send c_shutdown(true) to save and exit
 	m.Log("正在向 %s 发送关闭指令...", shardName)
-	utils.RunCommand("screen", "-S", screenName, "-p", "0", "-X", "stuff", cmd)
+	if err := shard.SendCommand("c_shutdown(true)"); err != nil {
+		m.Log("发送关闭指令失败了喵，只能强制停止了: %v", err)
+		policy.TimeoutSeconds = 0
+	}
+
+	outcome, err := awaitShutdown(shard, lines, policy)
+	result.Outcome = outcome
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+	}
 
-	// Wait a bit
-	time.Sleep(3 * time.Second)
+	switch outcome {
+	case StopGraceful:
+		m.Log("%s 已保存并正常退出 (用时 %v)", shardName, result.Duration.Round(time.Second))
+	case StopTerminated:
+		m.Log("%s 迟迟不退出，已用 SIGTERM 结束喵 (用时 %v)", shardName, result.Duration.Round(time.Second))
+	case StopKilled:
+		m.Log("%s 卡死了，已用 SIGKILL 强制结束喵，存档可能没保存上！", shardName)
+	default:
+		m.Log("%s 停不下来喵: %v", shardName, err)
+	}
+	return result
 }
 
-// IsRunning checks if the server is running
-// 检查服务器是否运行
-func (m *Manager) IsRunning() bool {
-	out, err := utils.RunCommandOutput("screen", "-ls")
-	if err != nil {
-		return false
+// IsRunning checks if any shard of the cluster is running
+// 检查指定存档是否在运行
+func (m *Manager) IsRunning(cluster string) bool {
+	for _, running := range m.Supervisor.RunningClusters() {
+		if running == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+// RunningClusters returns the clusters that currently have live shards
+// 返回正在运行的存档列表
+func (m *Manager) RunningClusters() []string {
+	return m.Supervisor.RunningClusters()
+}
+
+// SelectRunningCluster lets the user pick one of the running clusters.
+// With a single running cluster it is picked without asking.
+// 从正在运行的存档中选择一个，只有一个时直接选中
+func (m *Manager) SelectRunningCluster(prompt string) string {
+	clusters := m.RunningClusters()
+	switch len(clusters) {
+	case 0:
+		m.Log("现在没有正在运行的存档喵~")
+		return ""
+	case 1:
+		return clusters[0]
 	}
-	return strings.Contains(out, "dst_Master") || strings.Contains(out, "dst_Caves")
+
+	m.Log("%s", prompt)
+	for i, name := range clusters {
+		fmt.Printf("  [%d] %s\n", i+1, name)
+	}
+
+	input := utils.ReadInput("请输入编号 (输入 0 取消): ")
+	if input == "0" {
+		return ""
+	}
+
+	var index int
+	_, err := fmt.Sscanf(input, "%d", &index)
+	if err != nil || index < 1 || index > len(clusters) {
+		m.Log("输入的编号不对喵~")
+		return ""
+	}
+
+	return clusters[index-1]
 }
@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCountdownCancelled is returned when a stop or restart countdown is cancelled
+// 倒计时被取消
+var ErrCountdownCancelled = errors.New("倒计时已取消")
+
+// countdowns tracks the running stop/restart countdown of each cluster
+// 记录每个存档正在进行的倒计时
+type countdowns struct {
+	mu      sync.Mutex
+	pending map[string]chan struct{}
+}
+
+func newCountdowns() *countdowns {
+	return &countdowns{pending: make(map[string]chan struct{})}
+}
+
+// Announce broadcasts a message to every running shard of a cluster
+// 向存档所有运行中的世界发送公告
+func (m *Manager) Announce(cluster, message string) error {
+	shards := m.RunningShards(cluster)
+	if len(shards) == 0 {
+		return fmt.Errorf("存档 %s 没有在运行", cluster)
+	}
+	command := "c_announce(" + strconv.Quote(message) + ")"
+	var failed []string
+	for _, name := range shards {
+		if shard, ok := m.Supervisor.Get(cluster, name); ok {
+			if err := shard.SendCommand(command); err != nil {
+				failed = append(failed, name)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("公告发送失败: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// StopServerAfter announces a countdown and then stops the cluster
+// 倒计时公告后停止存档
+func (m *Manager) StopServerAfter(cluster string, grace time.Duration) ([]StopResult, error) {
+	settings, _ := m.LoadClusterSettings(cluster)
+	if err := m.countdown(cluster, grace, settings.Countdown.StopMessage, settings.Countdown); err != nil {
+		return nil, err
+	}
+	return m.StopServer(cluster), nil
+}
+
+// RestartServer announces a countdown, stops the cluster and starts it again
+// 倒计时公告后重启存档
+func (m *Manager) RestartServer(cluster string, grace time.Duration) error {
+	settings, _ := m.LoadClusterSettings(cluster)
+	if err := m.countdown(cluster, grace, settings.Countdown.RestartMessage, settings.Countdown); err != nil {
+		return err
+	}
+	m.StopServer(cluster)
+	return m.StartServer(cluster)
+}
+
+// CancelCountdown aborts the countdown of a cluster, reporting whether one
+// was running
+// 取消存档的倒计时
+func (m *Manager) CancelCountdown(cluster string) bool {
+	m.countdowns.mu.Lock()
+	defer m.countdowns.mu.Unlock()
+	ch, ok := m.countdowns.pending[cluster]
+	if ok {
+		close(ch)
+		delete(m.countdowns.pending, cluster)
+	}
+	return ok
+}
+
+// CountdownActive reports whether a countdown is running for a cluster
+// 存档是否正在倒计时
+func (m *Manager) CountdownActive(cluster string) bool {
+	m.countdowns.mu.Lock()
+	defer m.countdowns.mu.Unlock()
+	_, ok := m.countdowns.pending[cluster]
+	return ok
+}
+
+// countdown announces the template at the start and at every configured
+// point that fits in grace, then returns when grace has passed
+func (m *Manager) countdown(cluster string, grace time.Duration, template string, cfg CountdownSettings) error {
+	if grace <= 0 || !m.IsRunning(cluster) {
+		return nil
+	}
+
+	m.countdowns.mu.Lock()
+	if _, ok := m.countdowns.pending[cluster]; ok {
+		m.countdowns.mu.Unlock()
+		return fmt.Errorf("存档 %s 已经在倒计时了喵", cluster)
+	}
+	cancel := make(chan struct{})
+	m.countdowns.pending[cluster] = cancel
+	m.countdowns.mu.Unlock()
+
+	defer func() {
+		m.countdowns.mu.Lock()
+		if m.countdowns.pending[cluster] == cancel {
+			delete(m.countdowns.pending, cluster)
+		}
+		m.countdowns.mu.Unlock()
+	}()
+
+	var points []time.Duration
+	for _, seconds := range cfg.PointsSeconds {
+		if point := time.Duration(seconds) * time.Second; point > 0 && point < grace {
+			points = append(points, point)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] > points[j] })
+
+	announce := func(remaining time.Duration) {
+		message := strings.ReplaceAll(template, "{time}", formatRemaining(remaining))
+		m.Log("[%s] %s", cluster, message)
+		if err := m.Announce(cluster, message); err != nil {
+			m.Log("倒计时公告发送失败了喵: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(grace)
+	announce(grace)
+	for _, point := range append(points, 0) {
+		select {
+		case <-time.After(time.Until(deadline.Add(-point))):
+		case <-cancel:
+			m.Log("存档 %s 的倒计时已取消喵~", cluster)
+			if cfg.CancelMessage != "" {
+				m.Announce(cluster, cfg.CancelMessage)
+			}
+			return ErrCountdownCancelled
+		}
+		if point > 0 {
+			announce(point)
+		}
+	}
+	return nil
+}
+
+// formatRemaining renders a duration the way players read it
+// 把剩余时间格式化成玩家易读的形式
+func formatRemaining(d time.Duration) string {
+	d = d.Round(time.Second)
+	minutes := int(d / time.Minute)
+	seconds := int((d % time.Minute) / time.Second)
+	switch {
+	case minutes > 0 && seconds > 0:
+		return fmt.Sprintf("%d 分 %d 秒", minutes, seconds)
+	case minutes > 0:
+		return fmt.Sprintf("%d 分钟", minutes)
+	default:
+		return fmt.Sprintf("%d 秒", seconds)
+	}
+}
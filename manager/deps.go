@@ -0,0 +1,298 @@
+package manager
+
+import (
+	"dst-manager/utils"
+	"dst-manager/utils/distro"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// DependencyOptions controls how missing system packages are installed
+// 安装系统依赖的选项
+type DependencyOptions struct {
+	// DryRun prints the commands that would run instead of running them
+	DryRun bool `json:"dry_run"`
+	// Rootless never calls sudo and only reports what is missing, for
+	// users who cannot or do not want to install packages themselves
+	Rootless bool `json:"rootless"`
+}
+
+// PackageStatus is one system package the server needs
+// 服务端需要的一个系统包
+type PackageStatus struct {
+	Name      string `json:"name"`
+	Installed bool   `json:"installed"`
+}
+
+// DependencyReport says which packages are there and what was, or would
+// be, run to install the rest
+// 系统依赖的检查结果，以及已执行（或将要执行）的命令
+type DependencyReport struct {
+	Distro         distro.OSRelease `json:"distro"`
+	PackageManager string           `json:"package_manager"`
+	Packages       []PackageStatus  `json:"packages"`
+	Missing        []string         `json:"missing"`
+	Commands       []string         `json:"commands"`
+}
+
+// packageManager knows the package names and commands of one distro family
+type packageManager struct {
+	name string
+	// binary is looked up on PATH to confirm the manager is there
+	binary string
+	// packages lists what SteamCMD (32-bit) and the server need; a
+	// package with alternatives is satisfied by any of them, the first
+	// available one is installed
+	packages  [][]string
+	installed func(pkg string) bool
+	available func(pkg string) bool
+	// prepare returns commands to run before installing missing
+	prepare func(missing []string) [][]string
+	install func(missing []string) []string
+}
+
+// succeeds runs a read-only query and reports whether it exited cleanly
+func succeeds(name string, args ...string) bool {
+	return exec.Command(name, args...).Run() == nil
+}
+
+func rpmInstalled(pkg string) bool {
+	return succeeds("rpm", "-q", pkg)
+}
+
+var packageManagers = map[string]*packageManager{
+	"apt": {
+		name:   "apt",
+		binary: "apt-get",
+		packages: [][]string{
+			// lib32gcc1 was renamed in Debian 11 and Ubuntu 20.04
+			{"lib32gcc-s1", "lib32gcc1"},
+			{"lib32stdc++6"},
+			{"libcurl4-gnutls-dev:i386"},
+		},
+		installed: func(pkg string) bool {
+			out, err := exec.Command("dpkg-query", "-W", "-f=${Status}", pkg).Output()
+			return err == nil && strings.Contains(string(out), "install ok installed")
+		},
+		available: func(pkg string) bool {
+			return succeeds("apt-cache", "show", pkg)
+		},
+		prepare: func(missing []string) [][]string {
+			var cmds [][]string
+			for _, pkg := range missing {
+				if strings.HasSuffix(pkg, ":i386") {
+					out, _ := exec.Command("dpkg", "--print-foreign-architectures").Output()
+					if !strings.Contains(string(out), "i386") {
+						cmds = append(cmds, []string{"dpkg", "--add-architecture", "i386"})
+					}
+					break
+				}
+			}
+			return append(cmds, []string{"apt-get", "update"})
+		},
+		install: func(missing []string) []string {
+			return append([]string{"apt-get", "install", "-y"}, missing...)
+		},
+	},
+	"dnf": {
+		name:      "dnf",
+		binary:    "dnf",
+		packages:  [][]string{{"glibc.i686"}, {"libstdc++.i686"}, {"libcurl.i686"}},
+		installed: rpmInstalled,
+		install: func(missing []string) []string {
+			return append([]string{"dnf", "install", "-y"}, missing...)
+		},
+	},
+	"yum": {
+		name:      "yum",
+		binary:    "yum",
+		packages:  [][]string{{"glibc.i686"}, {"libstdc++.i686"}, {"libcurl.i686"}},
+		installed: rpmInstalled,
+		install: func(missing []string) []string {
+			return append([]string{"yum", "install", "-y"}, missing...)
+		},
+	},
+	"pacman": {
+		name:   "pacman",
+		binary: "pacman",
+		// The lib32 packages need the multilib repository enabled
+		packages: [][]string{{"lib32-gcc-libs"}, {"lib32-glibc"}, {"libcurl-gnutls"}, {"lib32-libcurl-gnutls"}},
+		installed: func(pkg string) bool {
+			return succeeds("pacman", "-Q", pkg)
+		},
+		install: func(missing []string) []string {
+			return append([]string{"pacman", "-S", "--needed", "--noconfirm"}, missing...)
+		},
+	},
+	"zypper": {
+		name:      "zypper",
+		binary:    "zypper",
+		packages:  [][]string{{"libgcc_s1-32bit"}, {"libstdc++6-32bit"}, {"libcurl4-32bit"}},
+		installed: rpmInstalled,
+		install: func(missing []string) []string {
+			return append([]string{"zypper", "--non-interactive", "install"}, missing...)
+		},
+	},
+}
+
+// detectPackageManager picks the package manager from os-release, falling
+// back to whichever one is on PATH
+func detectPackageManager(release distro.OSRelease) (*packageManager, error) {
+	var name string
+	switch {
+	case release.Is("debian", "ubuntu"):
+		name = "apt"
+	case release.Is("fedora", "rhel", "centos"):
+		name = "dnf"
+		if !utils.CheckCommandExists("dnf") {
+			name = "yum"
+		}
+	case release.Is("arch"):
+		name = "pacman"
+	case release.Is("suse", "opensuse", "sles") || strings.HasPrefix(release.ID, "opensuse"):
+		name = "zypper"
+	}
+	if pm := packageManagers[name]; pm != nil && utils.CheckCommandExists(pm.binary) {
+		return pm, nil
+	}
+	for _, name := range []string{"apt", "dnf", "yum", "pacman", "zypper"} {
+		if pm := packageManagers[name]; utils.CheckCommandExists(pm.binary) {
+			return pm, nil
+		}
+	}
+	return nil, fmt.Errorf("不认识这个系统的包管理器喵 (%s)，请手动安装 32 位的 libgcc、libstdc++ 和 libcurl", release.PrettyName)
+}
+
+// CheckDependencies reports which system packages are installed without
+// changing anything
+// 检查系统依赖是否已安装，不做任何修改
+func (m *Manager) CheckDependencies() (*DependencyReport, error) {
+	report, _, err := m.checkDependencies()
+	return report, err
+}
+
+func (m *Manager) checkDependencies() (*DependencyReport, *packageManager, error) {
+	if runtime.GOOS != "linux" {
+		return nil, nil, fmt.Errorf("只能检查 Linux 系统的依赖喵")
+	}
+	release, err := distro.Read(distro.OSReleasePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("读取 %s 失败: %v", distro.OSReleasePath, err)
+	}
+	report := &DependencyReport{Distro: release, Packages: []PackageStatus{}, Missing: []string{}, Commands: []string{}}
+	pm, err := detectPackageManager(release)
+	if err != nil {
+		return report, nil, err
+	}
+	report.PackageManager = pm.name
+
+	for _, alternatives := range pm.packages {
+		status := PackageStatus{Name: alternatives[0]}
+		for _, pkg := range alternatives {
+			if pm.installed(pkg) {
+				status = PackageStatus{Name: pkg, Installed: true}
+				break
+			}
+		}
+		if !status.Installed && len(alternatives) > 1 && pm.available != nil {
+			for _, pkg := range alternatives {
+				if pm.available(pkg) {
+					status.Name = pkg
+					break
+				}
+			}
+		}
+		report.Packages = append(report.Packages, status)
+		if !status.Installed {
+			report.Missing = append(report.Missing, status.Name)
+		}
+	}
+	return report, pm, nil
+}
+
+// InstallDependencies installs necessary system dependencies, through
+// sudo unless running as root. Without sudo it only reports what is
+// missing.
+// 安装必要的系统依赖
+func (m *Manager) InstallDependencies() {
+	if runtime.GOOS != "linux" {
+		m.Log("主人，咱喵检测到不是Linux系统，跳过依赖安装步骤哦~")
+		return
+	}
+	options := DependencyOptions{}
+	if os.Geteuid() != 0 && !utils.CheckCommandExists("sudo") {
+		m.Log("没有找到 sudo，小花酱只能列出缺少的依赖喵")
+		options.Rootless = true
+	}
+	if _, err := m.InstallDependenciesWith(options); err != nil {
+		m.Log("依赖安装出错了喵: %v", err)
+	}
+}
+
+// InstallDependenciesWith checks the system packages and installs the
+// missing ones as options allow
+// 按选项检查并安装缺少的系统依赖
+func (m *Manager) InstallDependenciesWith(options DependencyOptions) (*DependencyReport, error) {
+	m.Log("正在检查系统依赖喵...")
+	report, pm, err := m.checkDependencies()
+	if err != nil {
+		return report, err
+	}
+	m.Log("系统: %s，包管理器: %s", report.Distro.PrettyName, report.PackageManager)
+	for _, pkg := range report.Packages {
+		if pkg.Installed {
+			m.Log("  ✓ %s", pkg.Name)
+		} else {
+			m.Log("  ✗ %s (未安装)", pkg.Name)
+		}
+	}
+	if len(report.Missing) == 0 {
+		m.Log("系统依赖都已经安装好啦！")
+		return report, nil
+	}
+
+	var cmds [][]string
+	if pm.prepare != nil {
+		cmds = pm.prepare(report.Missing)
+	}
+	cmds = append(cmds, pm.install(report.Missing))
+	// Rootless reports the commands for whoever has root to run
+	if os.Geteuid() != 0 && !options.Rootless {
+		for i, cmd := range cmds {
+			cmds[i] = append([]string{"sudo"}, cmd...)
+		}
+	}
+	for _, cmd := range cmds {
+		report.Commands = append(report.Commands, strings.Join(cmd, " "))
+	}
+
+	switch {
+	case options.Rootless:
+		m.Log("缺少 %d 个依赖: %s", len(report.Missing), strings.Join(report.Missing, " "))
+		m.Log("请让管理员用 root 执行以下命令喵:")
+		for _, cmd := range report.Commands {
+			m.Log("  %s", cmd)
+		}
+		return report, nil
+	case options.DryRun:
+		m.Log("试运行模式，将会执行以下命令喵:")
+		for _, cmd := range report.Commands {
+			m.Log("  %s", cmd)
+		}
+		return report, nil
+	}
+
+	if os.Geteuid() != 0 {
+		m.Log("正在安装缺少的依赖，可能需要主人输入密码呢...")
+	}
+	for _, cmd := range cmds {
+		if err := utils.RunCommand(cmd[0], cmd[1:]...); err != nil {
+			return report, fmt.Errorf("执行 %s 失败: %v", strings.Join(cmd, " "), err)
+		}
+	}
+	m.Log("系统依赖安装完成啦！")
+	return report, nil
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package manager
+
+import "syscall"
+
+// diskFree returns the bytes available to unprivileged users on the
+// filesystem holding path
+// 获取 path 所在文件系统的可用空间
+func diskFree(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package manager
+
+import "fmt"
+
+// diskFree is not implemented on Windows
+// Windows 下暂不支持
+func diskFree(path string) (int64, error) {
+	return 0, fmt.Errorf("Windows 下暂不支持")
+}
@@ -0,0 +1,461 @@
+package manager
+
+import (
+	"bufio"
+	"dst-manager/utils"
+	"dst-manager/utils/clusterUtils"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DoctorStatus is the outcome of one diagnostic check
+// 诊断结果
+type DoctorStatus string
+
+const (
+	DoctorPass DoctorStatus = "pass"
+	DoctorWarn DoctorStatus = "warn"
+	DoctorFail DoctorStatus = "fail"
+)
+
+// DoctorCheck is one item of the diagnostics report
+// 诊断报告中的一项
+type DoctorCheck struct {
+	Category string       `json:"category"`
+	Name     string       `json:"name"`
+	Status   DoctorStatus `json:"status"`
+	Detail   string       `json:"detail"`
+	// Fix suggests what to do about a warning or failure
+	Fix string `json:"fix,omitempty"`
+}
+
+// DoctorReport is the result of Doctor
+// 环境诊断报告
+type DoctorReport struct {
+	Status    DoctorStatus  `json:"status"`
+	Checks    []DoctorCheck `json:"checks"`
+	Pass      int           `json:"pass"`
+	Warn      int           `json:"warn"`
+	Fail      int           `json:"fail"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+const (
+	// A fresh server install takes about 3GB; updates need room for the
+	// download next to it
+	diskWarnBytes = 8 << 30
+	diskFailBytes = 2 << 30
+	// A two shard cluster uses about 1.5GB once worlds are generated
+	memoryWarnBytes = 2 << 30
+	memoryFailBytes = 1 << 30
+)
+
+// Default ports of a shard when server.ini leaves them out
+var defaultShardPorts = map[string]int{
+	"server_port":         10999,
+	"master_server_port":  27016,
+	"authentication_port": 8766,
+}
+
+type doctor struct {
+	m      *Manager
+	report *DoctorReport
+}
+
+func (d *doctor) add(category, name string, status DoctorStatus, detail, fix string) {
+	d.report.Checks = append(d.report.Checks, DoctorCheck{
+		Category: category,
+		Name:     name,
+		Status:   status,
+		Detail:   detail,
+		Fix:      fix,
+	})
+	switch status {
+	case DoctorPass:
+		d.report.Pass++
+	case DoctorWarn:
+		d.report.Warn++
+	case DoctorFail:
+		d.report.Fail++
+	}
+}
+
+// Doctor checks the environment for the usual reasons a server will not
+// install or start
+// 检查运行环境中导致服务端无法安装或启动的常见问题
+func (m *Manager) Doctor() *DoctorReport {
+	d := &doctor{m: m, report: &DoctorReport{Checks: []DoctorCheck{}, CheckedAt: time.Now()}}
+	d.checkArch()
+	d.checkMemory()
+	d.checkDisk()
+	d.checkBinaries()
+	d.checkLibraries()
+	d.checkPorts()
+	d.checkTokens()
+	d.checkPermissions()
+
+	d.report.Status = DoctorPass
+	if d.report.Warn > 0 {
+		d.report.Status = DoctorWarn
+	}
+	if d.report.Fail > 0 {
+		d.report.Status = DoctorFail
+	}
+	return d.report
+}
+
+func (d *doctor) checkArch() {
+	switch runtime.GOARCH {
+	case "amd64":
+		d.add("系统", "CPU 架构", DoctorPass, runtime.GOOS+"/amd64", "")
+	case "386":
+		d.add("系统", "CPU 架构", DoctorWarn, runtime.GOOS+"/386，只能运行 32 位服务端",
+			"32 位服务端已不再更新，建议换用 64 位系统")
+	default:
+		d.add("系统", "CPU 架构", DoctorFail, runtime.GOOS+"/"+runtime.GOARCH+"，服务端只提供 x86 版本",
+			"请使用 x86_64 服务器；ARM 机器需要借助 box86/box64 转译，管理器无法直接运行")
+	}
+	if runtime.GOOS != "linux" {
+		d.add("系统", "操作系统", DoctorWarn, runtime.GOOS+"，管理器主要在 Linux 上使用", "建议在 Linux 上运行服务端")
+	}
+}
+
+func (d *doctor) checkDisk() {
+	cfg := d.m.Config
+	seen := make(map[string]bool)
+	for _, dir := range []string{cfg.DSTInstallDir, cfg.ClusterDir, cfg.BackupDir} {
+		path := existingParent(dir)
+		free, err := diskFree(path)
+		if err != nil {
+			d.add("磁盘", dir, DoctorWarn, "无法读取剩余空间: "+err.Error(), "")
+			continue
+		}
+		// Directories on the same filesystem report the same space
+		key := fmt.Sprintf("%s:%d", filepath.VolumeName(path), free)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		detail := fmt.Sprintf("%s 剩余 %.1f GB", path, float64(free)/(1<<30))
+		switch {
+		case free < diskFailBytes:
+			d.add("磁盘", "剩余空间", DoctorFail, detail, "服务端安装和更新至少需要几个 GB 的空间，请清理旧备份或旧版本 (菜单 12)")
+		case free < diskWarnBytes:
+			d.add("磁盘", "剩余空间", DoctorWarn, detail, "空间有些紧张，更新时可能不够用，建议清理旧备份")
+		default:
+			d.add("磁盘", "剩余空间", DoctorPass, detail, "")
+		}
+	}
+}
+
+// existingParent returns dir or the nearest parent that exists
+func existingParent(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+func (d *doctor) checkMemory() {
+	total, available, err := readMemInfo()
+	if err != nil {
+		d.add("系统", "内存", DoctorWarn, "无法读取 /proc/meminfo: "+err.Error(), "")
+		return
+	}
+	detail := fmt.Sprintf("共 %.1f GB，可用 %.1f GB", float64(total)/(1<<30), float64(available)/(1<<30))
+	switch {
+	case available < memoryFailBytes:
+		d.add("系统", "内存", DoctorFail, detail, "可用内存不足，世界生成时可能被系统杀掉；请关闭其他程序、减少世界数量或增加 swap")
+	case total < memoryWarnBytes || available < memoryWarnBytes:
+		d.add("系统", "内存", DoctorWarn, detail, "带洞穴的存档大约需要 2GB 内存，建议增加内存或 swap")
+	default:
+		d.add("系统", "内存", DoctorPass, detail, "")
+	}
+}
+
+// readMemInfo returns MemTotal and MemAvailable in bytes
+func readMemInfo() (total, available int64, err error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb << 10
+		case "MemAvailable:":
+			available = kb << 10
+		}
+	}
+	return total, available, scanner.Err()
+}
+
+// executable checks that path is a file anyone may run
+func executable(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("不存在")
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("不是普通文件")
+	}
+	if info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("没有执行权限")
+	}
+	return nil
+}
+
+func (d *doctor) checkBinaries() {
+	steamcmd := filepath.Join(d.m.Config.SteamCMDDir, "steamcmd.sh")
+	if err := executable(steamcmd); err != nil {
+		d.add("程序", "SteamCMD", DoctorFail, steamcmd+": "+err.Error(), "在菜单 1 中安装 SteamCMD")
+	} else {
+		d.add("程序", "SteamCMD", DoctorPass, steamcmd, "")
+	}
+
+	binPath, err := d.m.ServerBinary("")
+	if err == nil {
+		err = executable(binPath)
+	}
+	if err != nil {
+		d.add("程序", "服务端", DoctorFail, binPath+": "+err.Error(), "在菜单 1 中安装服务端")
+	} else {
+		d.add("程序", "服务端", DoctorPass, binPath, "")
+	}
+
+	if utils.CheckCommandExists("ldd") {
+		d.add("程序", "ldd", DoctorPass, "已安装", "")
+	} else {
+		d.add("程序", "ldd", DoctorWarn, "没有找到 ldd", "请用系统的包管理器安装 ldd")
+	}
+}
+
+func (d *doctor) checkLibraries() {
+	if !utils.CheckCommandExists("ldd") {
+		return
+	}
+	binPath, _ := d.m.ServerBinary("")
+	targets := []struct{ name, path string }{
+		{"服务端依赖库", binPath},
+		{"SteamCMD 依赖库", filepath.Join(d.m.Config.SteamCMDDir, "linux32", "steamcmd")},
+	}
+	fix := "在菜单 1 中安装系统依赖"
+	if report, err := d.m.CheckDependencies(); err == nil && len(report.Missing) > 0 {
+		fix = "缺少系统包 " + strings.Join(report.Missing, " ") + "，在菜单 1 中安装系统依赖"
+	}
+	for _, target := range targets {
+		if _, err := os.Stat(target.path); err != nil {
+			continue
+		}
+		// The server loads its own libs from lib64 next to bin64
+		cmd := exec.Command("ldd", target.path)
+		cmd.Dir = filepath.Dir(target.path)
+		cmd.Env = append(os.Environ(), "LD_LIBRARY_PATH="+filepath.Join(filepath.Dir(filepath.Dir(target.path)), "lib64"))
+		out, err := cmd.CombinedOutput()
+		var missing []string
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.Contains(line, "not found") {
+				missing = append(missing, strings.TrimSpace(strings.SplitN(line, "=>", 2)[0]))
+			}
+		}
+		switch {
+		case len(missing) > 0:
+			d.add("依赖库", target.name, DoctorFail, "缺少 "+strings.Join(missing, ", "), fix)
+		case err != nil:
+			d.add("依赖库", target.name, DoctorWarn, "ldd 执行失败: "+strings.TrimSpace(string(out)), fix)
+		default:
+			d.add("依赖库", target.name, DoctorPass, "依赖库齐全", "")
+		}
+	}
+}
+
+// shardPort is one UDP port a shard binds
+type shardPort struct {
+	cluster, shard, key string
+	port                int
+}
+
+func (d *doctor) checkPorts() {
+	var ports []shardPort
+	for _, cluster := range d.m.ListClusters() {
+		shards, err := d.m.ListShards(cluster)
+		if err != nil {
+			continue
+		}
+		for _, shard := range shards {
+			ini, err := clusterUtils.ReadIni(filepath.Join(d.m.Config.ClusterDir, cluster, shard.Name, "server.ini"))
+			if err != nil {
+				continue
+			}
+			values := map[string]string{
+				"server_port":         ini["NETWORK"]["server_port"],
+				"master_server_port":  ini["STEAM"]["master_server_port"],
+				"authentication_port": ini["STEAM"]["authentication_port"],
+			}
+			keys := make([]string, 0, len(values))
+			for key := range values {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				port := defaultShardPorts[key]
+				if values[key] != "" {
+					p, err := strconv.Atoi(values[key])
+					if err != nil || p <= 0 || p > 65535 {
+						d.add("端口", cluster+"/"+shard.Name+" "+key, DoctorFail, "无效的端口: "+values[key],
+							"在 "+shard.Name+"/server.ini 中填写 1-65535 之间的端口")
+						continue
+					}
+					port = p
+				}
+				ports = append(ports, shardPort{cluster, shard.Name, key, port})
+			}
+		}
+	}
+
+	// Shards of one cluster always run together, and a running cluster
+	// already holds its ports, so those clashes stop a start. Stopped
+	// clusters only clash if they are started at the same time.
+	owners := make(map[int][]shardPort)
+	running := make(map[string]bool)
+	for _, p := range ports {
+		owners[p.port] = append(owners[p.port], p)
+		if _, ok := running[p.cluster]; !ok {
+			running[p.cluster] = d.m.IsRunning(p.cluster)
+		}
+	}
+	for _, p := range ports {
+		name := p.cluster + "/" + p.shard + " " + p.key
+		detail := fmt.Sprintf("UDP %d", p.port)
+		if len(owners[p.port]) > 1 {
+			var others []string
+			fatal := false
+			for _, other := range owners[p.port] {
+				if other == p {
+					continue
+				}
+				others = append(others, other.cluster+"/"+other.shard+" "+other.key)
+				if other.cluster == p.cluster || running[other.cluster] || running[p.cluster] {
+					fatal = true
+				}
+			}
+			if fatal {
+				d.add("端口", name, DoctorFail, detail+" 与 "+strings.Join(others, ", ")+" 冲突",
+					"同时运行的世界端口必须互不相同，请修改 server.ini")
+			} else {
+				d.add("端口", name, DoctorWarn, detail+" 与 "+strings.Join(others, ", ")+" 相同，不能同时运行",
+					"如果要同时运行这些存档，请修改 server.ini 换一个端口")
+			}
+			continue
+		}
+		if running[p.cluster] {
+			d.add("端口", name, DoctorPass, detail+" (存档运行中，由它自己占用)", "")
+			continue
+		}
+		conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", p.port))
+		if err != nil {
+			d.add("端口", name, DoctorFail, detail+" 已被其他程序占用", fmt.Sprintf("用 ss -ulpn | grep %d 找出占用端口的程序，或在 server.ini 中换一个端口", p.port))
+			continue
+		}
+		conn.Close()
+		d.add("端口", name, DoctorPass, detail+" 可用", "")
+	}
+}
+
+func (d *doctor) checkTokens() {
+	for _, cluster := range d.m.ListClusters() {
+		data, err := os.ReadFile(filepath.Join(d.m.Config.ClusterDir, cluster, "cluster_token.txt"))
+		token := strings.TrimSpace(string(data))
+		switch {
+		case os.IsNotExist(err) || (err == nil && token == ""):
+			d.add("令牌", cluster, DoctorFail, "没有 cluster_token.txt 或内容为空",
+				"在 Klei 账号页面 (https://accounts.klei.com/account/game/servers?game=DontStarveTogether) 生成令牌，写入存档目录的 cluster_token.txt")
+		case err != nil:
+			d.add("令牌", cluster, DoctorFail, "读取 cluster_token.txt 失败: "+err.Error(), "检查文件权限")
+		case !strings.HasPrefix(token, "pds-"):
+			d.add("令牌", cluster, DoctorWarn, "令牌格式看起来不对 (通常以 pds- 开头)", "确认复制的是完整的服务器令牌")
+		default:
+			d.add("令牌", cluster, DoctorPass, "已配置", "")
+		}
+	}
+}
+
+func (d *doctor) checkPermissions() {
+	cfg := d.m.Config
+	paths := []struct{ name, dir string }{
+		{"SteamCMDDir", cfg.SteamCMDDir},
+		{"DSTInstallDir", cfg.DSTInstallDir},
+		{"ClusterDir", cfg.ClusterDir},
+		{"BackupDir", cfg.BackupDir},
+		{"DataDir", cfg.DataDir},
+		{"InstallsDir", cfg.InstallsDir},
+	}
+	for _, p := range paths {
+		info, err := os.Stat(p.dir)
+		if os.IsNotExist(err) {
+			parent := existingParent(p.dir)
+			if err := writable(parent); err != nil {
+				d.add("权限", p.name, DoctorFail, p.dir+" 不存在，且无法在 "+parent+" 中创建: "+err.Error(),
+					fmt.Sprintf("用 sudo mkdir -p %s && sudo chown %s %s 创建目录", p.dir, currentUser(), p.dir))
+			} else {
+				d.add("权限", p.name, DoctorPass, p.dir+" 不存在，需要时会自动创建", "")
+			}
+			continue
+		}
+		if err != nil {
+			d.add("权限", p.name, DoctorFail, p.dir+": "+err.Error(), "")
+			continue
+		}
+		if !info.IsDir() {
+			d.add("权限", p.name, DoctorFail, p.dir+" 不是目录", "删除或移走这个文件")
+			continue
+		}
+		if err := writable(p.dir); err != nil {
+			d.add("权限", p.name, DoctorFail, p.dir+" 不可写: "+err.Error(),
+				fmt.Sprintf("用 sudo chown -R %s %s 修改目录所有者", currentUser(), p.dir))
+			continue
+		}
+		d.add("权限", p.name, DoctorPass, p.dir+" 可写", "")
+	}
+}
+
+// writable tries to create a file in dir
+func writable(dir string) error {
+	f, err := os.CreateTemp(dir, ".dst-manager-doctor-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func currentUser() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return strconv.Itoa(os.Getuid())
+}
@@ -1,112 +1,250 @@
 package manager
 
 import (
-	"dst-manager/utils"
+	"dst-manager/utils/steamcmd"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
+	"strings"
+	"time"
 )
 
-// InstallDependencies installs necessary system dependencies
-// 安装必要的系统依赖
-func (m *Manager) InstallDependencies() {
-	if runtime.GOOS != "linux" {
-		m.Log("主人，咱喵检测到不是Linux系统，跳过依赖安装步骤哦~")
-		return
-	}
-
-	m.Log("正在检查并安装系统依赖，可能需要主人输入密码呢...")
-	// Ubuntu/Debian dependencies for SteamCMD (32-bit) and DST
-	deps := []string{"lib32gcc-s1", "lib32stdc++6", "libcurl4-gnutls-dev:i386", "screen"}
-	
-	// Add architecture if needed
-	utils.RunCommand("sudo", "dpkg", "--add-architecture", "i386")
-	utils.RunCommand("sudo", "apt-get", "update")
-	
-	args := append([]string{"apt-get", "install", "-y"}, deps...)
-	if err := utils.RunCommand("sudo", args...); err != nil {
-		m.Log("依赖安装出错了喵: %v", err)
-	} else {
-		m.Log("系统依赖安装完成啦！")
-	}
-}
-
 // InstallSteamCMD downloads and installs SteamCMD
 // 下载并安装 SteamCMD
-func (m *Manager) InstallSteamCMD() error {
+func (m *Manager) InstallSteamCMD() (err error) {
 	steamPath := filepath.Join(m.Config.SteamCMDDir, "steamcmd.sh")
 	if _, err := os.Stat(steamPath); err == nil {
 		m.Log("SteamCMD 已经安装过了喵~")
 		return nil
 	}
+	options, err := m.LoadInstallOptions()
+	if err != nil {
+		m.Log("读取安装选项失败了喵，使用默认选项: %v", err)
+	}
+	if options.SteamCMDArchive != "" {
+		return m.InstallSteamCMDFrom(options.SteamCMDArchive)
+	}
+	defer m.observeInstall("steamcmd", time.Now(), &err)
 
 	m.Log("开始下载 SteamCMD...")
 	if err := m.Config.EnsureDirs(); err != nil {
 		return err
 	}
+	tarUrl := options.SteamCMDURL
+	if tarUrl == "" {
+		tarUrl = steamcmd.DefaultURL
+	}
+	client, err := steamcmd.NewClient(options.HTTPProxy)
+	if err != nil {
+		return err
+	}
 
-	// Download steamcmd_linux.tar.gz
-	tarUrl := "https://steamcdn-a.akamaihd.net/client/installer/steamcmd_linux.tar.gz"
+	// Download steamcmd_linux.tar.gz; an interrupted download is resumed
 	tarPath := filepath.Join(m.Config.SteamCMDDir, "steamcmd_linux.tar.gz")
-	
-	// We use curl or wget. Let's assume curl exists or use Go's http client.
-	// For simplicity, let's use system commands if available, checking utils.
-	if utils.CheckCommandExists("curl") {
-		utils.RunCommand("curl", "-o", tarPath, tarUrl)
-	} else if utils.CheckCommandExists("wget") {
-		utils.RunCommand("wget", "-O", tarPath, tarUrl)
-	} else {
-		return fmt.Errorf("没有找到 curl 或 wget，咱喵没法下载文件呢")
+	logged := int64(-1)
+	progress := func(done, total int64) {
+		if total > 0 && done*4/total > logged {
+			logged = done * 4 / total
+			m.Log("已下载 %d%% (%d KB / %d KB)", done*100/total, done>>10, total>>10)
+		}
+	}
+	if err := steamcmd.DownloadVerified(client, tarUrl, tarPath, options.SteamCMDSHA256, 5, progress); err != nil {
+		if errors.Is(err, steamcmd.ErrChecksum) {
+			m.Log("下载的 SteamCMD 与记录的校验和不一致喵！如果 Valve 更新了安装包，请在安装选项中清空或更新 steamcmd_sha256")
+			return err
+		}
+		return fmt.Errorf("下载 SteamCMD 失败: %v", err)
 	}
+	defer os.Remove(tarPath)
 
 	// Extract
 	m.Log("正在解压 SteamCMD...")
-	if err := utils.RunCommand("tar", "-xvzf", tarPath, "-C", m.Config.SteamCMDDir); err != nil {
+	if err := steamcmd.ExtractTarGz(tarPath, m.Config.SteamCMDDir); err != nil {
 		return err
 	}
+	if _, err := os.Stat(steamPath); err != nil {
+		return fmt.Errorf("压缩包中没有 steamcmd.sh")
+	}
 
-	// Remove tar
-	os.Remove(tarPath)
+	if options.SteamCMDSHA256 == "" {
+		// Nothing to compare with the first time; pin the checksum of a
+		// download that unpacked fine for later installs
+		sum, err := steamcmd.FileSHA256(tarPath)
+		if err == nil {
+			options.SteamCMDSHA256 = sum
+			err = m.SaveInstallOptions(options)
+		}
+		if err != nil {
+			m.Log("记录 SteamCMD 校验和失败了喵: %v", err)
+		} else {
+			m.Log("已记录 SteamCMD 的校验和 %s，以后下载会按它校验喵", sum)
+		}
+	}
 
 	m.Log("SteamCMD 安装成功啦！")
 	return nil
 }
 
-// InstallDST installs or updates the DST server
-// 安装或更新 DST 服务端
+// InstallDST installs or updates the DST server with the saved install options
+// 按保存的安装选项安装或更新 DST 服务端
 func (m *Manager) InstallDST() error {
-	m.Log("准备安装/更新 饥荒联机版服务端...")
-	
-	steamCmdPath := filepath.Join(m.Config.SteamCMDDir, "steamcmd.sh")
+	options, err := m.LoadInstallOptions()
+	if err != nil {
+		m.Log("读取安装选项失败了喵，使用默认选项: %v", err)
+	}
+	return m.InstallDSTWith(*options)
+}
+
+// InstallDSTWith installs or updates the DST server from the given branch.
+// Progress and the outcome are kept as a job, see Jobs.
+// 从指定分支安装或更新 DST 服务端，进度记录在任务中
+func (m *Manager) InstallDSTWith(options InstallOptions) error {
+	job, err := m.startInstallJob(options)
+	if err != nil {
+		m.Log("%v", err)
+		return err
+	}
+	return m.runInstallJob(options, job)
+}
+
+// StartInstallDST runs InstallDST in the background and returns its job
+// 在后台安装服务端，返回对应的任务
+func (m *Manager) StartInstallDST() (Job, error) {
+	options, err := m.LoadInstallOptions()
+	if err != nil {
+		m.Log("读取安装选项失败了喵，使用默认选项: %v", err)
+	}
+	job, err := m.startInstallJob(*options)
+	if err != nil {
+		return Job{}, err
+	}
+	go m.runInstallJob(*options, job)
+	started, _ := m.Job(job.id)
+	return started, nil
+}
+
+func (m *Manager) startInstallJob(options InstallOptions) (*jobHandle, error) {
+	description := fmt.Sprintf("安装/更新服务端 (%s 分支)", options.BranchName())
+	if options.DSTArchive != "" {
+		description = "从本地安装服务端 (" + filepath.Base(options.DSTArchive) + ")"
+	}
+	// Mods are written into the install being replaced
+	job, err := m.jobs.start(jobInstallDST, description, jobUpdateMods)
+	if err != nil {
+		return nil, err
+	}
+	// Checked once the job runs, as from then on clusters on the current
+	// install cannot start
+	if err := m.checkCurrentInstallIdle(); err != nil {
+		job.finish(err)
+		return nil, err
+	}
+	return job, nil
+}
+
+// runInstallJob installs from the local archive when one is configured,
+// otherwise through SteamCMD
+func (m *Manager) runInstallJob(options InstallOptions, job *jobHandle) error {
+	if options.DSTArchive != "" {
+		return m.installOffline(options.DSTArchive, options, job)
+	}
+	return m.installDST(options, job)
+}
+
+func (m *Manager) installDST(options InstallOptions, job *jobHandle) (err error) {
+	defer m.observeInstall("dst", time.Now(), &err)
+	defer func() { job.finish(err) }()
+	logf := func(format string, a ...interface{}) {
+		m.Log(format, a...)
+		job.logf(format, a...)
+	}
+	logf("准备安装/更新 饥荒联机版服务端 (%s 分支)...", options.BranchName())
+
 	installDir := m.Config.DSTInstallDir
 
-	// Retry loop
-	maxRetries := 3
-	for i := 0; i < maxRetries; i++ {
-		if i > 0 {
-			m.Log("安装失败了，正在尝试第 %d 次重试喵...", i+1)
+	// Keep the build being replaced so clusters can be pinned to it, but
+	// only when SteamCMD is about to install a different one
+	if current, err := m.InstallInfo(); err == nil && current.Installed && options.KeepVersions > 0 {
+		latest := ""
+		if appInfo, err := m.FetchAppInfo(); err != nil {
+			logf("查询最新版本失败了喵，这次不保留当前版本: %v", err)
+		} else if branch, ok := appInfo.Branch(options.BranchName()); ok {
+			latest = branch.BuildID
 		}
-
-		// cmd: ./steamcmd.sh +force_install_dir <path> +login anonymous +app_update 343050 validate +quit
-		// Note: Sometimes running login first separately helps
-		args := []string{
-			"+force_install_dir", installDir,
-			"+login", "anonymous",
-			"+app_update", "343050", "validate",
-			"+quit",
+		if err := m.keepCurrentInstall(options.KeepVersions, latest); err != nil {
+			logf("保留当前版本失败了喵，继续更新: %v", err)
 		}
+	}
 
-		err := utils.RunCommand(steamCmdPath, args...)
-		if err == nil {
-			m.Log("饥荒联机版服务端安装/更新完成！可以开始冒险了喵！")
+	// cmd: ./steamcmd.sh +force_install_dir <path> +login anonymous +app_update 343050 -beta <branch> validate +quit
+	args := []string{
+		"+force_install_dir", installDir,
+		"+login", "anonymous",
+	}
+	args = append(args, options.steamArgs()...)
+	args = append(args, "+quit")
+
+	// How often to retry depends on why SteamCMD failed
+	for attempt := 1; ; attempt++ {
+		job.attempt(attempt)
+		failure := m.runSteamCMD(args, job)
+		if failure == nil {
+			// SteamCMD can exit cleanly without installing anything, the
+			// appmanifest tells what actually happened
+			if err := m.verifyInstall(options); err != nil {
+				failure = &steamcmd.Failure{Kind: steamcmd.FailureUpdateState, Line: err.Error()}
+			}
+		}
+		if failure == nil {
+			logf("饥荒联机版服务端安装/更新完成！可以开始冒险了喵！")
 			return nil
 		}
-		
-		m.Log("安装 DST 服务端出错: %v", err)
+
+		job.failure(*failure)
+		policy := installRetryPolicy(failure.Kind)
+		logf("安装 DST 服务端出错: %s", policy.Message)
+		logf("  | %s", failure.Line)
+		if attempt > policy.Retries {
+			if policy.Retries > 0 {
+				logf("呜呜...重试了 %d 次还是失败了喵。", policy.Retries)
+			}
+			return &InstallError{Failure: *failure, Message: policy.Message, Attempts: attempt}
+		}
+		delay := policy.Delay * time.Duration(attempt)
+		logf("%v 后进行第 %d 次重试喵...", delay, attempt+1)
+		time.Sleep(delay)
 	}
+}
 
-	m.Log("呜呜...重试了 %d 次还是失败了喵。", maxRetries)
-	m.Log("如果错误是 'Missing configuration'，请确认您的服务器架构是否为 x86/amd64。")
-	return fmt.Errorf("安装失败")
+// verifyInstall checks the appmanifest after SteamCMD reported success and
+// records the installed build
+// 检查 SteamCMD 完成后 appmanifest 记录的安装状态，并记录已安装的版本
+func (m *Manager) verifyInstall(options InstallOptions) error {
+	info, err := m.InstallInfo()
+	if err != nil {
+		return fmt.Errorf("读取安装信息失败: %v", err)
+	}
+	if !info.Installed {
+		return fmt.Errorf("SteamCMD 没有生成 %s", filepath.Base(info.ManifestPath))
+	}
+	if !info.FullyInstalled() {
+		return fmt.Errorf("安装未完成，状态: %s", strings.Join(info.State, ", "))
+	}
+	if !strings.EqualFold(info.Branch, options.BranchName()) {
+		// A wrong beta password leaves the previous branch in place
+		return fmt.Errorf("请求的是 %s 分支，但安装的是 %s 分支，请检查分支名和密码", options.BranchName(), info.Branch)
+	}
+	m.Log("已安装版本 %s (分支 %s，占用 %d MB)", info.BuildID, info.Branch, info.SizeOnDisk>>20)
+
+	err = m.recordInstalledBuild(InstalledBuild{
+		Branch:      info.Branch,
+		BuildID:     info.BuildID,
+		Validated:   options.Validate,
+		InstalledAt: time.Now(),
+	})
+	if err != nil {
+		m.Log("记录已安装版本失败了喵: %v", err)
+	}
+	return nil
 }
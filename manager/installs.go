@@ -0,0 +1,332 @@
+package manager
+
+import (
+	"dst-manager/utils"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// currentInstall is the id of the install in DSTInstallDir, the one
+// InstallDST updates; clusters without a pin run from it
+// DSTInstallDir 中的安装，未固定版本的存档都使用它
+const currentInstall = "current"
+
+// Install is one server install: the current one or a kept earlier build
+// 一份服务端安装：当前版本或保留的旧版本
+type Install struct {
+	ID       string   `json:"id"`
+	Dir      string   `json:"dir"`
+	BuildID  string   `json:"build_id"`
+	Branch   string   `json:"branch"`
+	Current  bool     `json:"current"`
+	PinnedBy []string `json:"pinned_by"`
+	// KeptAt is when an earlier build was set aside
+	KeptAt time.Time `json:"kept_at,omitempty"`
+}
+
+// installID names the directory an earlier build is kept in
+func installID(info InstallInfo) string {
+	if strings.EqualFold(info.Branch, publicBranch) {
+		return info.BuildID
+	}
+	return info.BuildID + "-" + info.Branch
+}
+
+// Installs lists the current install followed by the kept builds, newest
+// build first
+// 列出当前安装和保留的旧版本，版本号新的在前
+func (m *Manager) Installs() ([]Install, error) {
+	pins := m.installPins()
+
+	var installs []Install
+	if info, err := m.InstallInfo(); err == nil && info.Installed {
+		installs = append(installs, Install{
+			ID:       currentInstall,
+			Dir:      info.InstallDir,
+			BuildID:  info.BuildID,
+			Branch:   info.Branch,
+			Current:  true,
+			PinnedBy: pins[currentInstall],
+		})
+	}
+
+	entries, err := os.ReadDir(m.Config.InstallsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return installs, err
+	}
+	var kept []Install
+	for _, entry := range entries {
+		// Half copied builds start with a dot
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(m.Config.InstallsDir, entry.Name())
+		info, err := readInstallInfo(dir)
+		if err != nil || !info.Installed {
+			continue
+		}
+		install := Install{
+			ID:       entry.Name(),
+			Dir:      dir,
+			BuildID:  info.BuildID,
+			Branch:   info.Branch,
+			PinnedBy: pins[entry.Name()],
+		}
+		if stat, err := entry.Info(); err == nil {
+			install.KeptAt = stat.ModTime()
+		}
+		kept = append(kept, install)
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return buildNumber(kept[i].BuildID) > buildNumber(kept[j].BuildID)
+	})
+	return append(installs, kept...), nil
+}
+
+// buildNumber orders build ids; Steam build ids only ever grow
+func buildNumber(id string) int64 {
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return n
+}
+
+// installPins maps install ids to the clusters pinned to them
+func (m *Manager) installPins() map[string][]string {
+	pins := make(map[string][]string)
+	for _, cluster := range m.ListClusters() {
+		settings, _ := m.LoadClusterSettings(cluster)
+		id := settings.Install
+		if id == "" {
+			id = currentInstall
+		}
+		pins[id] = append(pins[id], cluster)
+	}
+	return pins
+}
+
+// installDir returns the directory of an install id
+func (m *Manager) installDir(id string) (string, error) {
+	if id == "" || id == currentInstall {
+		return m.Config.DSTInstallDir, nil
+	}
+	if strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("无效的版本: %q", id)
+	}
+	dir := filepath.Join(m.Config.InstallsDir, id)
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("找不到版本 %s，可能已经被清理了", id)
+	}
+	return dir, nil
+}
+
+// clusterInstallDir returns the install a cluster runs from
+// 返回存档使用的服务端目录
+func (m *Manager) clusterInstallDir(cluster string) (string, error) {
+	settings, _ := m.LoadClusterSettings(cluster)
+	return m.installDir(settings.Install)
+}
+
+// FollowsCurrentInstall reports whether a cluster runs from the current
+// install, the one updates replace
+// 存档是否使用当前版本（会被更新替换的那一份）
+func (m *Manager) FollowsCurrentInstall(cluster string) bool {
+	settings, _ := m.LoadClusterSettings(cluster)
+	return settings.Install == "" || settings.Install == currentInstall
+}
+
+// runningOnCurrentInstall returns the running clusters that are not
+// pinned to a kept build, the ones an install would pull the files from
+// under
+func (m *Manager) runningOnCurrentInstall() []string {
+	var clusters []string
+	for _, cluster := range m.RunningClusters() {
+		if m.FollowsCurrentInstall(cluster) {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters
+}
+
+// ErrCurrentInstallInUse is returned when an install would replace the
+// files of running clusters
+// 有存档正在使用当前版本运行
+var ErrCurrentInstallInUse = errors.New("有存档正在使用当前版本运行")
+
+// checkCurrentInstallIdle refuses to replace the current install while
+// clusters run from it; updateAndRestart stops them first
+func (m *Manager) checkCurrentInstallIdle() error {
+	if clusters := m.runningOnCurrentInstall(); len(clusters) > 0 {
+		return fmt.Errorf("%w: %s，请先停止它们，或者通过更新 (菜单 11 或 /api/update) 自动停止并重启喵", ErrCurrentInstallInUse, strings.Join(clusters, ", "))
+	}
+	return nil
+}
+
+// ServerBinary returns the dedicated server executable for a cluster,
+// taken from the install the cluster is pinned to
+// 返回存档使用的服务端可执行文件（按存档固定的版本）
+func (m *Manager) ServerBinary(cluster string) (string, error) {
+	dir, err := m.clusterInstallDir(cluster)
+	if err != nil {
+		return "", err
+	}
+	// 64-bit executable is standard now
+	binPath := filepath.Join(dir, "bin64", "dontstarve_dedicated_server_nullrenderer_x64")
+	if _, err := os.Stat(binPath); os.IsNotExist(err) {
+		// Fallback to 32-bit
+		binPath = filepath.Join(dir, "bin", "dontstarve_dedicated_server_nullrenderer")
+	}
+	return binPath, nil
+}
+
+// PinInstall makes a cluster run from the given install; an empty id or
+// "current" follows the current install again
+// 固定存档使用的服务端版本，传空或 current 表示跟随当前版本
+func (m *Manager) PinInstall(cluster, id string) error {
+	if _, err := m.installDir(id); err != nil {
+		return err
+	}
+	settings, err := m.LoadClusterSettings(cluster)
+	if err != nil {
+		return err
+	}
+	if id == currentInstall {
+		id = ""
+	}
+	settings.Install = id
+	if err := m.SaveClusterSettings(cluster, settings); err != nil {
+		return err
+	}
+	if id == "" {
+		m.Log("存档 %s 将使用当前版本的服务端喵~", cluster)
+	} else {
+		m.Log("存档 %s 已固定使用版本 %s，重启后生效喵~", cluster, id)
+	}
+	return nil
+}
+
+// RollbackTarget returns the newest kept build older than the one the
+// cluster runs now
+// 返回比存档当前使用的版本更早的最新版本
+func (m *Manager) RollbackTarget(cluster string) (Install, error) {
+	dir, err := m.clusterInstallDir(cluster)
+	if err != nil {
+		return Install{}, err
+	}
+	info, err := readInstallInfo(dir)
+	if err != nil {
+		return Install{}, err
+	}
+	installs, err := m.Installs()
+	if err != nil {
+		return Install{}, err
+	}
+	for _, install := range installs {
+		if !install.Current && buildNumber(install.BuildID) < buildNumber(info.BuildID) {
+			return install, nil
+		}
+	}
+	return Install{}, fmt.Errorf("没有比 %s 更早的版本可以回退喵", info.BuildID)
+}
+
+// RollbackCluster pins a cluster to its RollbackTarget and, if it is
+// running, restarts it onto that build after the grace countdown
+// 把存档回退到上一个版本，运行中的存档会在倒计时后重启
+func (m *Manager) RollbackCluster(cluster string, grace time.Duration) (Install, error) {
+	target, err := m.RollbackTarget(cluster)
+	if err != nil {
+		return Install{}, err
+	}
+	if err := m.PinInstall(cluster, target.ID); err != nil {
+		return Install{}, err
+	}
+	m.Log("存档 %s 已回退到版本 %s", cluster, target.BuildID)
+	if m.IsRunning(cluster) {
+		if err := m.RestartServer(cluster, grace); err != nil {
+			return target, err
+		}
+	}
+	return target, nil
+}
+
+// keepCurrentInstall copies the current install aside before the build
+// next replaces it, so clusters can stay on or roll back to it, then
+// removes the oldest kept builds beyond keep. A copy is several GB, so
+// nothing is kept when next is the installed build or not known.
+// 确认要安装不同的版本时，先把当前版本复制一份保留下来，并清理超出数量的旧版本
+func (m *Manager) keepCurrentInstall(keep int, next string) error {
+	info, ok := m.installToKeep(keep, next)
+	if !ok {
+		return nil
+	}
+	return m.keepInstall(m.Config.DSTInstallDir, info, keep, utils.CopyTree)
+}
+
+// installToKeep returns the current install when it is complete and
+// next is a different build
+func (m *Manager) installToKeep(keep int, next string) (InstallInfo, bool) {
+	if keep <= 0 || next == "" {
+		return InstallInfo{}, false
+	}
+	info, err := m.InstallInfo()
+	if err != nil || !info.Installed || !info.FullyInstalled() || info.BuildID == "" || info.BuildID == next {
+		return InstallInfo{}, false
+	}
+	return info, true
+}
+
+// keepInstall puts the server tree at dir, holding the build info, into
+// InstallsDir; place copies or moves it there
+func (m *Manager) keepInstall(dir string, info InstallInfo, keep int, place func(src, dst string) error) error {
+	id := installID(info)
+	target := filepath.Join(m.Config.InstallsDir, id)
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		if err := os.MkdirAll(m.Config.InstallsDir, 0755); err != nil {
+			return err
+		}
+		m.Log("正在保留当前版本 %s，以便之后回退喵...", id)
+		partial := filepath.Join(m.Config.InstallsDir, "."+id+".partial")
+		os.RemoveAll(partial)
+		if err := place(dir, partial); err != nil {
+			os.RemoveAll(partial)
+			return fmt.Errorf("保留当前版本失败: %v", err)
+		}
+		if err := os.Rename(partial, target); err != nil {
+			os.RemoveAll(partial)
+			return err
+		}
+		// The tree keeps its old times; KeptAt should say when it was kept
+		now := time.Now()
+		os.Chtimes(target, now, now)
+	}
+
+	m.pruneInstalls(keep)
+	return nil
+}
+
+// pruneInstalls removes the oldest kept builds beyond keep, never one a
+// cluster is pinned to
+func (m *Manager) pruneInstalls(keep int) {
+	installs, err := m.Installs()
+	if err != nil {
+		return
+	}
+	kept := 0
+	for _, install := range installs {
+		if install.Current || len(install.PinnedBy) > 0 {
+			continue
+		}
+		kept++
+		if kept <= keep {
+			continue
+		}
+		m.Log("清理旧版本 %s 喵", install.ID)
+		if err := os.RemoveAll(install.Dir); err != nil {
+			m.Log("清理 %s 失败了喵: %v", install.Dir, err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package manager
+
+import (
+	"dst-manager/utils/steamcmd"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// jobInstallDST is the kind of InstallDST jobs
+	jobInstallDST = "install_dst"
+	// jobUpdateMods is the kind of UpdateMods jobs
+	jobUpdateMods = "update_mods"
+	// jobLogLines is how many log lines a job keeps
+	jobLogLines = 200
+	// maxJobs is how many finished jobs are remembered
+	maxJobs = 20
+)
+
+// ErrInstallInProgress is returned when the server files are already
+// being installed
+// 已经在安装中
+var ErrInstallInProgress = errors.New("服务端正在安装中，请等它完成喵")
+
+// ErrModUpdateInProgress is returned when mods are already being updated
+// 模组已经在更新中
+var ErrModUpdateInProgress = errors.New("模组正在更新中，请等它完成喵")
+
+// jobBusyErrors says why a second job of a kind cannot start
+var jobBusyErrors = map[string]error{
+	jobInstallDST: ErrInstallInProgress,
+	jobUpdateMods: ErrModUpdateInProgress,
+}
+
+// JobState is where a job is in its life
+// 任务状态
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// JobLogEntry is one line of a job's log
+// 任务日志中的一行
+type JobLogEntry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Job is a long running install or mod update, followed through the job API
+// 长时间运行的安装或模组更新任务，可以通过任务接口查看进度
+type Job struct {
+	ID          string             `json:"id"`
+	Kind        string             `json:"kind"`
+	Description string             `json:"description"`
+	State       JobState           `json:"state"`
+	Attempt     int                `json:"attempt"`
+	Progress    *steamcmd.Progress `json:"progress,omitempty"`
+	// Failure is the classified SteamCMD error of the last attempt
+	Failure    *steamcmd.Failure `json:"failure,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at,omitempty"`
+	Log        []JobLogEntry     `json:"log"`
+}
+
+// jobTracker keeps the running and recently finished jobs
+type jobTracker struct {
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	nextID int
+}
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{jobs: make(map[string]*Job)}
+}
+
+// start registers a running job, refusing a second job of the same kind
+// or one while a job of an excluded kind runs. Both are checked under the
+// lock, so two jobs that exclude each other never start together.
+func (t *jobTracker) start(kind, description string, excludes ...string) (*jobHandle, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, busy := range append([]string{kind}, excludes...) {
+		if t.runningLocked(busy) {
+			return nil, jobBusyErrors[busy]
+		}
+	}
+	t.nextID++
+	job := &Job{
+		ID:          fmt.Sprintf("%s-%d", kind, t.nextID),
+		Kind:        kind,
+		Description: description,
+		State:       JobRunning,
+		StartedAt:   time.Now(),
+		Log:         []JobLogEntry{},
+	}
+	t.jobs[job.ID] = job
+	t.prune()
+	return &jobHandle{tracker: t, id: job.ID}, nil
+}
+
+// running reports whether a job of the kind is running
+func (t *jobTracker) running(kind string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.runningLocked(kind)
+}
+
+func (t *jobTracker) runningLocked(kind string) bool {
+	for _, job := range t.jobs {
+		if job.Kind == kind && job.State == JobRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// prune forgets the oldest finished jobs beyond maxJobs
+func (t *jobTracker) prune() {
+	var finished []*Job
+	for _, job := range t.jobs {
+		if job.State != JobRunning {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].StartedAt.Before(finished[j].StartedAt)
+	})
+	for _, job := range finished[:len(finished)-maxJobs] {
+		delete(t.jobs, job.ID)
+	}
+}
+
+// update changes a job under the lock
+func (t *jobTracker) update(id string, change func(job *Job)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if job, ok := t.jobs[id]; ok {
+		change(job)
+	}
+}
+
+// snapshot copies a job so callers can read it without the lock
+func snapshot(job *Job) Job {
+	copied := *job
+	copied.Log = append([]JobLogEntry(nil), job.Log...)
+	if job.Progress != nil {
+		progress := *job.Progress
+		copied.Progress = &progress
+	}
+	if job.Failure != nil {
+		failure := *job.Failure
+		copied.Failure = &failure
+	}
+	return copied
+}
+
+// jobHandle is what the code doing the work reports through
+type jobHandle struct {
+	tracker *jobTracker
+	id      string
+}
+
+func (h *jobHandle) logf(format string, a ...interface{}) {
+	h.tracker.update(h.id, func(job *Job) {
+		job.Log = append(job.Log, JobLogEntry{Time: time.Now(), Message: fmt.Sprintf(format, a...)})
+		if len(job.Log) > jobLogLines {
+			job.Log = job.Log[len(job.Log)-jobLogLines:]
+		}
+	})
+}
+
+func (h *jobHandle) attempt(n int) {
+	h.tracker.update(h.id, func(job *Job) {
+		job.Attempt = n
+		job.Progress = nil
+	})
+}
+
+func (h *jobHandle) progress(p steamcmd.Progress) {
+	h.tracker.update(h.id, func(job *Job) { job.Progress = &p })
+}
+
+func (h *jobHandle) failure(f steamcmd.Failure) {
+	h.tracker.update(h.id, func(job *Job) { job.Failure = &f })
+}
+
+func (h *jobHandle) finish(err error) {
+	h.tracker.update(h.id, func(job *Job) {
+		job.FinishedAt = time.Now()
+		if err != nil {
+			job.State = JobFailed
+			job.Error = err.Error()
+			return
+		}
+		job.State = JobSucceeded
+		job.Failure = nil
+	})
+}
+
+// Jobs returns the running and recently finished jobs, newest first
+// 返回正在运行和最近完成的任务，新的在前
+func (m *Manager) Jobs() []Job {
+	m.jobs.mu.Lock()
+	defer m.jobs.mu.Unlock()
+	jobs := make([]Job, 0, len(m.jobs.jobs))
+	for _, job := range m.jobs.jobs {
+		jobs = append(jobs, snapshot(job))
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.After(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+// Job returns one job by id
+// 按 id 返回任务
+func (m *Manager) Job(id string) (Job, bool) {
+	m.jobs.mu.Lock()
+	defer m.jobs.mu.Unlock()
+	job, ok := m.jobs.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return snapshot(job), true
+}
@@ -2,21 +2,59 @@ package manager
 
 import (
 	"dst-manager/config"
+	"dst-manager/utils/metrics"
 	"fmt"
+	"sync"
 )
 
 // Manager handles the DST server operations
 // 管理器结构体
 type Manager struct {
-	Config *config.Config
+	Config     *config.Config
+	Supervisor *Supervisor
+	Scheduler  *Scheduler
+	Metrics    *metrics.Registry
+
+	watchdog    *watchdog
+	states      *stateTracker
+	countdowns  *countdowns
+	players     *playerTracker
+	monitor     *resourceMonitor
+	instruments *instruments
+	updates     *updater
+	jobs        *jobTracker
+	mods        *modTracker
 }
 
-// NewManager creates a new Manager instance
-// 创建新的管理器实例
+var (
+	instance *Manager
+	once     sync.Once
+)
+
+// NewManager returns the shared Manager instance, so the menu and the
+// HTTP API see the same shard processes
+// 返回共享的管理器实例，菜单和 HTTP 接口看到的是同一批进程
 func NewManager() *Manager {
-	return &Manager{
-		Config: config.NewConfig(),
-	}
+	once.Do(func() {
+		registry := metrics.NewRegistry()
+		instance = &Manager{
+			Config:      config.NewConfig(),
+			Supervisor:  NewSupervisor(),
+			Metrics:     registry,
+			watchdog:    newWatchdog(),
+			states:      newStateTracker(),
+			countdowns:  newCountdowns(),
+			players:     newPlayerTracker(),
+			monitor:     newResourceMonitor(),
+			instruments: newInstruments(registry),
+			updates:     newUpdater(),
+			jobs:        newJobTracker(),
+			mods:        newModTracker(),
+		}
+		instance.registerStateMetrics(registry)
+		instance.Scheduler = newScheduler(instance)
+	})
+	return instance
 }
 
 // Log prints a formatted message with a cute prefix
@@ -0,0 +1,163 @@
+package manager
+
+import (
+	"dst-manager/utils/metrics"
+	"os"
+	"strings"
+	"time"
+)
+
+// instruments are the metrics the manager updates as things happen; the
+// rest are read from current state on every scrape
+// 管理器在事件发生时更新的指标，其余指标在每次抓取时读取
+type instruments struct {
+	shardStarts    *metrics.Counter
+	shardCrashes   *metrics.Counter
+	shardRestarts  *metrics.Counter
+	backups        *metrics.Counter
+	backupDuration *metrics.Histogram
+	backupSize     *metrics.Gauge
+	installs       *metrics.Counter
+	installTime    *metrics.Histogram
+	httpDuration   *metrics.Histogram
+}
+
+func newInstruments(reg *metrics.Registry) *instruments {
+	return &instruments{
+		shardStarts: reg.Counter("dst_shard_starts_total",
+			"Shard processes started, including automatic restarts.", "cluster", "shard"),
+		shardCrashes: reg.Counter("dst_shard_crashes_total",
+			"Shard processes that exited without being asked to.", "cluster", "shard"),
+		shardRestarts: reg.Counter("dst_shard_restarts_total",
+			"Crashed shards restarted by the watchdog.", "cluster", "shard"),
+		backups: reg.Counter("dst_backups_total",
+			"Cluster backups by outcome.", "cluster", "outcome"),
+		backupDuration: reg.Histogram("dst_backup_duration_seconds",
+			"Time taken to back up a cluster.", metrics.DurationBuckets, "cluster"),
+		backupSize: reg.Gauge("dst_backup_last_size_bytes",
+			"Size of the latest successful backup of a cluster.", "cluster"),
+		installs: reg.Counter("dst_installs_total",
+			"SteamCMD and server installs or updates by outcome.", "component", "outcome"),
+		installTime: reg.Histogram("dst_install_duration_seconds",
+			"Time taken by SteamCMD and server installs or updates.", metrics.DurationBuckets, "component", "outcome"),
+		httpDuration: reg.Histogram("dst_manager_http_request_duration_seconds",
+			"Latency of the manager's HTTP API.", metrics.LatencyBuckets, "method", "route", "status"),
+	}
+}
+
+// HTTPRequestDuration is the histogram the HTTP API records its latency
+// in, registered once however many routers are built
+// HTTP 接口的延迟直方图，只注册一次
+func (m *Manager) HTTPRequestDuration() *metrics.Histogram {
+	return m.instruments.httpDuration
+}
+
+// outcomeLabel turns an error into the outcome label value
+func outcomeLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// observeInstall records an install or update that started at start; it
+// takes a pointer so it can be deferred before the result is known
+func (m *Manager) observeInstall(component string, start time.Time, err *error) {
+	outcome := outcomeLabel(*err)
+	m.instruments.installs.Inc(component, outcome)
+	m.instruments.installTime.Observe(time.Since(start).Seconds(), component, outcome)
+}
+
+// registerStateMetrics adds the gauges read from the manager's state on
+// every scrape
+// 注册每次抓取时从当前状态读取的指标
+func (m *Manager) registerStateMetrics(reg *metrics.Registry) {
+	up := reg.Gauge("dst_shard_up",
+		"Whether the shard is running and ready (1) or not (0).", "cluster", "shard")
+	state := reg.Gauge("dst_shard_state",
+		"Current lifecycle state of the shard; the series with value 1 is the current one.", "cluster", "shard", "state")
+	players := reg.Gauge("dst_players_online",
+		"Players connected to the shard.", "cluster", "shard")
+	rss := reg.Gauge("dst_shard_resident_memory_bytes",
+		"Resident memory of the shard process at the latest sample.", "cluster", "shard")
+	cpu := reg.Gauge("dst_shard_cpu_percent",
+		"CPU usage of the shard process at the latest sample, 100 per busy core.", "cluster", "shard")
+	threads := reg.Gauge("dst_shard_threads",
+		"Threads of the shard process at the latest sample.", "cluster", "shard")
+	fds := reg.Gauge("dst_shard_open_fds",
+		"Open file descriptors of the shard process at the latest sample.", "cluster", "shard")
+	stored := reg.Gauge("dst_backup_files",
+		"Backup archives kept in the backup directory.", "cluster")
+	storedBytes := reg.Gauge("dst_backup_files_bytes",
+		"Total size of the backup archives kept in the backup directory.", "cluster")
+
+	reg.OnCollect(func() {
+		for _, g := range []*metrics.Gauge{up, state, players, rss, cpu, threads, fds, stored, storedBytes} {
+			g.Reset()
+		}
+
+		m.states.mu.Lock()
+		for key, entry := range m.states.shards {
+			i := strings.LastIndex(key, "/")
+			cluster, shard := key[:i], key[i+1:]
+			value := 0.0
+			if entry.state == StateRunning {
+				value = 1
+			}
+			up.Set(value, cluster, shard)
+			state.Set(1, cluster, shard, string(entry.state))
+		}
+		m.states.mu.Unlock()
+
+		m.players.mu.Lock()
+		for cluster, online := range m.players.clusters {
+			counts := make(map[string]int)
+			for _, p := range online {
+				counts[p.Shard]++
+			}
+			for shard, n := range counts {
+				players.Set(float64(n), cluster, shard)
+			}
+		}
+		m.players.mu.Unlock()
+
+		for _, shard := range m.Supervisor.Running() {
+			current := m.ShardResources(shard.Cluster, shard.Name).Current
+			if current == nil {
+				continue
+			}
+			rss.Set(float64(current.RSSBytes), shard.Cluster, shard.Name)
+			cpu.Set(current.CPUPercent, shard.Cluster, shard.Name)
+			threads.Set(float64(current.Threads), shard.Cluster, shard.Name)
+			fds.Set(float64(current.FDs), shard.Cluster, shard.Name)
+		}
+
+		counts := make(map[string]float64)
+		sizes := make(map[string]float64)
+		entries, _ := os.ReadDir(m.Config.BackupDir)
+		for _, entry := range entries {
+			cluster, ok := backupCluster(entry.Name())
+			if entry.IsDir() || !ok {
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				counts[cluster]++
+				sizes[cluster] += float64(info.Size())
+			}
+		}
+		for cluster, n := range counts {
+			stored.Set(n, cluster)
+			storedBytes.Set(sizes[cluster], cluster)
+		}
+	})
+}
+
+// backupCluster returns the cluster of a file named by BackupFileName
+func backupCluster(name string) (string, bool) {
+	// backup_<cluster>_20060102_150405.tar.gz
+	const stamp = len("_20060102_150405.tar.gz")
+	if !strings.HasPrefix(name, "backup_") || !strings.HasSuffix(name, ".tar.gz") || len(name) <= len("backup_")+stamp {
+		return "", false
+	}
+	return name[len("backup_") : len(name)-stamp], true
+}
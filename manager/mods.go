@@ -0,0 +1,791 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"dst-manager/utils"
+	"dst-manager/utils/steamcmd"
+	"dst-manager/utils/vdf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// workshopAppID is the Workshop of Don't Starve Together, where mods
+	// are published
+	workshopAppID = "322330"
+	// modsSetupFile lists the mods the server downloads on start
+	modsSetupFile    = "dedicated_server_mods_setup.lua"
+	workshopManifest = "appworkshop_" + workshopAppID + ".acf"
+	modRecordsFile   = "mods.json"
+	// serverModsTimeout bounds a -only_update_server_mods run
+	serverModsTimeout = 30 * time.Minute
+)
+
+// ModMethod is how mods are downloaded
+// 模组的下载方式
+type ModMethod string
+
+const (
+	// ModMethodSteamCMD downloads with workshop_download_item and copies
+	// the items into the install
+	ModMethodSteamCMD ModMethod = "steamcmd"
+	// ModMethodServer runs the server with -only_update_server_mods, which
+	// downloads what dedicated_server_mods_setup.lua lists and exits
+	ModMethodServer ModMethod = "server"
+)
+
+// ModState is whether a mod is ready to use
+// 模组状态
+type ModState string
+
+const (
+	ModInstalled ModState = "installed"
+	ModMissing   ModState = "missing"
+	// ModFailed means the last update failed; older files may still be
+	// there
+	ModFailed   ModState = "failed"
+	ModUpdating ModState = "updating"
+)
+
+// ModStatus describes one Workshop mod of the current install
+// 当前安装中一个创意工坊模组的状态
+type ModStatus struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name,omitempty"`
+	Version string   `json:"version,omitempty"`
+	Status  ModState `json:"status"`
+	// Source is the directory the mod is in: ugc_mods for current
+	// Workshop items, mods for legacy ones
+	Source string `json:"source,omitempty"`
+	// InSetup is whether dedicated_server_mods_setup.lua lists the mod
+	InSetup bool `json:"in_setup"`
+	// UsedBy are the clusters that have the mod in a modoverrides.lua
+	UsedBy []string `json:"used_by"`
+	// TimeUpdated is when the author last updated the installed files,
+	// from the Workshop manifest
+	TimeUpdated time.Time `json:"time_updated,omitempty"`
+	// UpdatedAt is when the manager last updated the mod
+	UpdatedAt time.Time         `json:"updated_at,omitempty"`
+	Method    ModMethod         `json:"method,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Failure   *steamcmd.Failure `json:"failure,omitempty"`
+}
+
+// ModUpdateOptions selects what UpdateMods updates and how
+// 模组更新选项
+type ModUpdateOptions struct {
+	Method ModMethod `json:"method"`
+	// IDs limits the update to these mods; empty updates every known mod
+	IDs []string `json:"ids,omitempty"`
+}
+
+// modRecord is the outcome of the last update of a mod
+type modRecord struct {
+	Method    ModMethod         `json:"method"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	Error     string            `json:"error,omitempty"`
+	Failure   *steamcmd.Failure `json:"failure,omitempty"`
+}
+
+// modTracker knows which mods are being updated and guards the records
+type modTracker struct {
+	mu       sync.Mutex
+	updating map[string]bool
+}
+
+func newModTracker() *modTracker {
+	return &modTracker{updating: make(map[string]bool)}
+}
+
+// modRetryPolicies override the install policies for failures that mean
+// something else for a Workshop item
+var modRetryPolicies = map[steamcmd.FailureKind]retryPolicy{
+	steamcmd.FailureNoSubscription: {0, 0,
+		"Steam 拒绝了下载请求，模组可能已下架或设为私有"},
+	steamcmd.FailureUnknown: {1, 10 * time.Second,
+		"模组下载失败了，请确认模组 ID 正确且模组是公开的"},
+}
+
+func modRetryPolicy(kind steamcmd.FailureKind) retryPolicy {
+	if policy, ok := modRetryPolicies[kind]; ok {
+		return policy
+	}
+	return installRetryPolicy(kind)
+}
+
+var (
+	// ServerModSetup("378160973")
+	modSetupPattern = regexp.MustCompile(`ServerModSetup\(\s*["'](\d+)["']\s*\)`)
+	// ServerModCollectionSetup("379114180")
+	modCollectionPattern = regexp.MustCompile(`ServerModCollectionSetup\(\s*["'](\d+)["']\s*\)`)
+	// ["workshop-378160973"] = { enabled = true }
+	modOverridePattern = regexp.MustCompile(`\[\s*["']workshop-(\d+)["']\s*\]`)
+	modIDPattern       = regexp.MustCompile(`^\d+$`)
+	modInfoPatterns    = map[string]*regexp.Regexp{
+		"name":    regexp.MustCompile(`(?m)^\s*name\s*=\s*["'](.*?)["']`),
+		"version": regexp.MustCompile(`(?m)^\s*version\s*=\s*["'](.*?)["']`),
+	}
+)
+
+// stripLuaComments drops -- comments, so commented out mods do not count
+func stripLuaComments(data string) string {
+	lines := strings.Split(data, "\n")
+	for i, line := range lines {
+		if idx := strings.Index(line, "--"); idx >= 0 {
+			lines[i] = line[:idx]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func matchIDs(pattern *regexp.Regexp, data string) []string {
+	var ids []string
+	for _, match := range pattern.FindAllStringSubmatch(data, -1) {
+		ids = append(ids, match[1])
+	}
+	return ids
+}
+
+func (m *Manager) modsSetupPath() string {
+	return filepath.Join(m.Config.DSTInstallDir, "mods", modsSetupFile)
+}
+
+func (m *Manager) ugcModDir(id string) string {
+	return filepath.Join(m.Config.DSTInstallDir, "ugc_mods", "content", workshopAppID, id)
+}
+
+func (m *Manager) legacyModDir(id string) string {
+	return filepath.Join(m.Config.DSTInstallDir, "mods", "workshop-"+id)
+}
+
+// setupModIDs returns the mods and collections dedicated_server_mods_setup.lua lists
+func (m *Manager) setupModIDs() (mods, collections []string, err error) {
+	data, err := os.ReadFile(m.modsSetupPath())
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	lua := stripLuaComments(string(data))
+	return matchIDs(modSetupPattern, lua), matchIDs(modCollectionPattern, lua), nil
+}
+
+// clusterModIDs maps each mod found in a modoverrides.lua to its clusters
+func (m *Manager) clusterModIDs() map[string][]string {
+	used := make(map[string][]string)
+	for _, cluster := range m.ListClusters() {
+		shards, err := m.ListShards(cluster)
+		if err != nil {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, shard := range shards {
+			data, err := os.ReadFile(filepath.Join(m.Config.ClusterDir, cluster, shard.Name, "modoverrides.lua"))
+			if err != nil {
+				continue
+			}
+			for _, id := range matchIDs(modOverridePattern, stripLuaComments(string(data))) {
+				if !seen[id] {
+					seen[id] = true
+					used[id] = append(used[id], cluster)
+				}
+			}
+		}
+	}
+	return used
+}
+
+// readModInfo reads the name and version from a mod's modinfo.lua
+func readModInfo(dir string) (name, version string, ok bool) {
+	data, err := os.ReadFile(filepath.Join(dir, "modinfo.lua"))
+	if err != nil {
+		return "", "", false
+	}
+	if match := modInfoPatterns["name"].FindSubmatch(data); match != nil {
+		name = string(match[1])
+	}
+	if match := modInfoPatterns["version"].FindSubmatch(data); match != nil {
+		version = string(match[1])
+	}
+	return name, version, true
+}
+
+// workshopTimes reads when each installed item was last updated from a
+// Workshop manifest
+func workshopTimes(path string) map[string]time.Time {
+	times := make(map[string]time.Time)
+	f, err := os.Open(path)
+	if err != nil {
+		return times
+	}
+	defer f.Close()
+	doc, err := vdf.Parse(f)
+	if err != nil {
+		return times
+	}
+	installed := doc.Get("AppWorkshop", "WorkshopItemsInstalled")
+	if installed == nil {
+		return times
+	}
+	for _, item := range installed.Children {
+		if seconds, err := strconv.ParseInt(item.String("timeupdated"), 10, 64); err == nil && seconds > 0 {
+			times[item.Key] = time.Unix(seconds, 0)
+		}
+	}
+	return times
+}
+
+func (m *Manager) loadModRecords() map[string]modRecord {
+	records := make(map[string]modRecord)
+	data, err := os.ReadFile(filepath.Join(m.Config.DataDir, modRecordsFile))
+	if err == nil {
+		json.Unmarshal(data, &records)
+	}
+	return records
+}
+
+func (m *Manager) saveModRecords(results map[string]modRecord) error {
+	m.mods.mu.Lock()
+	defer m.mods.mu.Unlock()
+	records := m.loadModRecords()
+	for id, record := range results {
+		records[id] = record
+	}
+	if err := os.MkdirAll(m.Config.DataDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.Config.DataDir, modRecordsFile), data, 0644)
+}
+
+// knownModIDs returns every mod in the setup file or a modoverrides.lua
+func (m *Manager) knownModIDs() ([]string, error) {
+	setup, _, err := m.setupModIDs()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range setup {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for id := range m.clusterModIDs() {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sortModIDs(ids)
+	return ids, nil
+}
+
+func sortModIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) < len(ids[j])
+		}
+		return ids[i] < ids[j]
+	})
+}
+
+// Mods lists the Workshop mods of the current install: those in
+// dedicated_server_mods_setup.lua and those enabled by a cluster
+// 列出当前安装的创意工坊模组（安装设置文件中的和存档启用的）
+func (m *Manager) Mods() ([]ModStatus, error) {
+	setup, _, err := m.setupModIDs()
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %v", modsSetupFile, err)
+	}
+	inSetup := make(map[string]bool)
+	for _, id := range setup {
+		inSetup[id] = true
+	}
+	used := m.clusterModIDs()
+	ids, _ := m.knownModIDs()
+	times := workshopTimes(filepath.Join(m.Config.DSTInstallDir, "ugc_mods", workshopManifest))
+
+	m.mods.mu.Lock()
+	records := m.loadModRecords()
+	updating := make(map[string]bool)
+	for id := range m.mods.updating {
+		updating[id] = true
+	}
+	m.mods.mu.Unlock()
+
+	mods := make([]ModStatus, 0, len(ids))
+	for _, id := range ids {
+		mod := ModStatus{ID: id, Status: ModMissing, InSetup: inSetup[id], UsedBy: used[id]}
+		if mod.UsedBy == nil {
+			mod.UsedBy = []string{}
+		}
+		if name, version, ok := readModInfo(m.ugcModDir(id)); ok {
+			mod.Name, mod.Version, mod.Source, mod.Status = name, version, "ugc_mods", ModInstalled
+			mod.TimeUpdated = times[id]
+		} else if name, version, ok := readModInfo(m.legacyModDir(id)); ok {
+			mod.Name, mod.Version, mod.Source, mod.Status = name, version, "mods", ModInstalled
+		}
+		if record, ok := records[id]; ok {
+			mod.UpdatedAt, mod.Method = record.UpdatedAt, record.Method
+			if record.Error != "" {
+				mod.Status, mod.Error, mod.Failure = ModFailed, record.Error, record.Failure
+			}
+		}
+		if updating[id] {
+			mod.Status = ModUpdating
+		}
+		mods = append(mods, mod)
+	}
+	return mods, nil
+}
+
+// AddMods adds mods to dedicated_server_mods_setup.lua so the server
+// downloads them
+// 把模组加入 dedicated_server_mods_setup.lua
+func (m *Manager) AddMods(ids []string) error {
+	for _, id := range ids {
+		if !modIDPattern.MatchString(id) {
+			return fmt.Errorf("无效的模组 ID: %q (应为创意工坊链接中 id= 后面的数字)", id)
+		}
+	}
+	path := m.modsSetupPath()
+	if _, err := os.Stat(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("还没有安装服务端，找不到 mods 目录喵")
+	}
+	setup, _, err := m.setupModIDs()
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool)
+	for _, id := range setup {
+		listed[id] = true
+	}
+	var lines []string
+	for _, id := range ids {
+		if !listed[id] {
+			listed[id] = true
+			lines = append(lines, fmt.Sprintf("ServerModSetup(\"%s\")\n", id))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	data = append(data, strings.Join(lines, "")...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	m.Log("已把 %d 个模组加入 %s 喵", len(lines), modsSetupFile)
+	return nil
+}
+
+// UpdateMods downloads or updates mods into the current install. Clusters
+// pinned to a kept install keep the mods they have.
+// 下载或更新当前安装中的模组，固定了旧版本的存档不受影响
+func (m *Manager) UpdateMods(options ModUpdateOptions) error {
+	job, ids, err := m.startModJob(options)
+	if err != nil {
+		m.Log("%v", err)
+		return err
+	}
+	return m.runModJob(options.Method, ids, job)
+}
+
+// StartUpdateMods runs UpdateMods in the background and returns its job
+// 在后台更新模组，返回对应的任务
+func (m *Manager) StartUpdateMods(options ModUpdateOptions) (Job, error) {
+	job, ids, err := m.startModJob(options)
+	if err != nil {
+		return Job{}, err
+	}
+	go m.runModJob(options.Method, ids, job)
+	started, _ := m.Job(job.id)
+	return started, nil
+}
+
+func (m *Manager) startModJob(options ModUpdateOptions) (*jobHandle, []string, error) {
+	switch options.Method {
+	case ModMethodSteamCMD, ModMethodServer:
+	default:
+		return nil, nil, fmt.Errorf("不支持的更新方式: %q (可选 steamcmd 或 server)", options.Method)
+	}
+	ids := options.IDs
+	if len(ids) == 0 {
+		known, err := m.knownModIDs()
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = known
+	}
+	for _, id := range ids {
+		if !modIDPattern.MatchString(id) {
+			return nil, nil, fmt.Errorf("无效的模组 ID: %q", id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil, fmt.Errorf("没有需要更新的模组喵，请先添加模组或在存档中启用模组")
+	}
+	// Mods are written into the install, which must not be replaced meanwhile
+	job, err := m.jobs.start(jobUpdateMods, fmt.Sprintf("更新 %d 个模组 (%s)", len(ids), options.Method), jobInstallDST)
+	if err != nil {
+		return nil, nil, err
+	}
+	return job, ids, nil
+}
+
+func (m *Manager) runModJob(method ModMethod, ids []string, job *jobHandle) (err error) {
+	defer func() { job.finish(err) }()
+	logf := func(format string, a ...interface{}) {
+		m.Log(format, a...)
+		job.logf(format, a...)
+	}
+	m.mods.mu.Lock()
+	for _, id := range ids {
+		m.mods.updating[id] = true
+	}
+	m.mods.mu.Unlock()
+	defer func() {
+		m.mods.mu.Lock()
+		for _, id := range ids {
+			delete(m.mods.updating, id)
+		}
+		m.mods.mu.Unlock()
+	}()
+
+	var results map[string]modRecord
+	if method == ModMethodServer {
+		logf("正在用服务端 (-only_update_server_mods) 更新 %d 个模组喵...", len(ids))
+		results, err = m.updateModsWithServer(ids, job, logf)
+	} else {
+		logf("正在用 SteamCMD 更新 %d 个模组喵...", len(ids))
+		results, err = m.updateModsWithSteamCMD(ids, job, logf)
+	}
+	if saveErr := m.saveModRecords(results); saveErr != nil {
+		logf("保存模组状态失败了喵: %v", saveErr)
+	}
+	if err != nil {
+		logf("模组更新失败了喵: %v", err)
+		return err
+	}
+
+	var failed []string
+	for _, id := range ids {
+		if results[id].Error != "" {
+			failed = append(failed, id)
+		}
+	}
+	if len(failed) > 0 {
+		err = fmt.Errorf("%d 个模组更新失败: %s", len(failed), strings.Join(failed, ", "))
+		logf("%v", err)
+		return err
+	}
+	logf("%d 个模组都更新好啦！重启存档后生效喵~", len(ids))
+	return nil
+}
+
+// updateModsWithSteamCMD downloads the items with SteamCMD, retrying the
+// failed ones by the kind of failure, and moves them into the install
+func (m *Manager) updateModsWithSteamCMD(ids []string, job *jobHandle, logf func(string, ...interface{})) (map[string]modRecord, error) {
+	results := make(map[string]modRecord)
+	if _, err := os.Stat(filepath.Join(m.Config.SteamCMDDir, "steamcmd.sh")); err != nil {
+		return results, fmt.Errorf("还没有安装 SteamCMD 喵")
+	}
+	if _, err := os.Stat(filepath.Join(m.Config.DSTInstallDir, "mods")); err != nil {
+		return results, fmt.Errorf("还没有安装服务端，找不到 mods 目录喵")
+	}
+	workshopDir := filepath.Join(m.Config.DataDir, "workshop")
+	if err := os.MkdirAll(workshopDir, 0755); err != nil {
+		return results, err
+	}
+
+	ugcUpdated := false
+	pending := ids
+	for attempt := 1; len(pending) > 0; attempt++ {
+		job.attempt(attempt)
+		// cmd: ./steamcmd.sh +force_install_dir <path> +login anonymous +workshop_download_item 322330 <id> ... +quit
+		args := []string{"+force_install_dir", workshopDir, "+login", "anonymous"}
+		for _, id := range pending {
+			args = append(args, "+workshop_download_item", workshopAppID, id)
+		}
+		args = append(args, "+quit")
+
+		items := make(map[string]steamcmd.WorkshopItem)
+		failure := m.runSteamCMDLines(args, job, func(line string) bool {
+			item, ok := steamcmd.ParseWorkshopItem(line)
+			if ok {
+				items[item.ID] = item
+			}
+			return ok
+		})
+
+		var retry []string
+		var delay time.Duration
+		for _, id := range pending {
+			item, reported := items[id]
+			f := item.Failure
+			if reported && f == nil {
+				ugc, err := m.placeWorkshopItem(workshopDir, item)
+				if err != nil {
+					logf("  ✗ %s: %v", id, err)
+					results[id] = modRecord{Method: ModMethodSteamCMD, UpdatedAt: time.Now(), Error: err.Error()}
+					continue
+				}
+				ugcUpdated = ugcUpdated || ugc
+				logf("  ✓ %s", id)
+				results[id] = modRecord{Method: ModMethodSteamCMD, UpdatedAt: time.Now()}
+				continue
+			}
+			if f == nil {
+				f = failure
+			}
+			if f == nil {
+				f = &steamcmd.Failure{Kind: steamcmd.FailureUnknown, Line: "SteamCMD 没有报告模组 " + id + " 的下载结果"}
+			}
+			job.failure(*f)
+			policy := modRetryPolicy(f.Kind)
+			results[id] = modRecord{Method: ModMethodSteamCMD, UpdatedAt: time.Now(), Error: policy.Message, Failure: f}
+			if attempt <= policy.Retries {
+				retry = append(retry, id)
+				if d := policy.Delay * time.Duration(attempt); d > delay {
+					delay = d
+				}
+				continue
+			}
+			logf("  ✗ %s: %s", id, policy.Message)
+			logf("    | %s", f.Line)
+		}
+		pending = retry
+		if len(pending) > 0 {
+			logf("%d 个模组下载失败，%v 后进行第 %d 次尝试喵...", len(pending), delay, attempt+1)
+			time.Sleep(delay)
+		}
+	}
+
+	if ugcUpdated {
+		src := filepath.Join(workshopDir, "steamapps", "workshop", workshopManifest)
+		dst := filepath.Join(m.Config.DSTInstallDir, "ugc_mods", workshopManifest)
+		if err := mergeWorkshopManifest(src, dst); err != nil {
+			logf("更新 %s 失败了喵，服务端启动时可能会重新下载这些模组: %v", workshopManifest, err)
+		}
+	}
+	return results, nil
+}
+
+// placeWorkshopItem moves a downloaded item into the install. Current
+// items hold the mod files and go to ugc_mods; legacy items are a zip
+// that is unpacked into mods/workshop-<id>. It reports whether the item
+// went to ugc_mods.
+func (m *Manager) placeWorkshopItem(workshopDir string, item steamcmd.WorkshopItem) (bool, error) {
+	src := item.Path
+	if src == "" {
+		src = filepath.Join(workshopDir, "steamapps", "workshop", "content", workshopAppID, item.ID)
+	}
+	if _, err := os.Stat(filepath.Join(src, "modinfo.lua")); err == nil {
+		dst := m.ugcModDir(item.ID)
+		partial := dst + ".partial"
+		os.RemoveAll(partial)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return false, err
+		}
+		// Copied rather than moved, SteamCMD keeps its download as a cache
+		if err := utils.CopyTree(src, partial); err != nil {
+			os.RemoveAll(partial)
+			return false, fmt.Errorf("复制模组文件失败: %v", err)
+		}
+		return true, swapDir(partial, dst)
+	}
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if entry.IsDir() || !(strings.HasSuffix(name, ".bin") || strings.HasSuffix(name, ".zip")) {
+			continue
+		}
+		dst := m.legacyModDir(item.ID)
+		partial := dst + ".partial"
+		os.RemoveAll(partial)
+		if err := steamcmd.ExtractZip(filepath.Join(src, entry.Name()), partial); err != nil {
+			os.RemoveAll(partial)
+			return false, err
+		}
+		if _, err := os.Stat(filepath.Join(partial, "modinfo.lua")); err != nil {
+			os.RemoveAll(partial)
+			return false, fmt.Errorf("下载的模组中没有 modinfo.lua")
+		}
+		return false, swapDir(partial, dst)
+	}
+	return false, fmt.Errorf("下载的模组中没有 modinfo.lua")
+}
+
+// swapDir replaces dst with the fully written partial
+func swapDir(partial, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		os.RemoveAll(partial)
+		return err
+	}
+	return os.Rename(partial, dst)
+}
+
+// mergeWorkshopManifest copies the entries SteamCMD wrote for the items
+// it downloaded into the server's Workshop manifest, so the server sees
+// them as up to date and does not download them again
+func mergeWorkshopManifest(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	from, err := vdf.Parse(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	to := vdf.NewSection("")
+	if f, err := os.Open(dst); err == nil {
+		to, err = vdf.Parse(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	// The server's own entries win for everything but the items
+	app := from.Get("AppWorkshop")
+	if app == nil {
+		return fmt.Errorf("%s 中没有 AppWorkshop", src)
+	}
+	target := to.Section("AppWorkshop")
+	for _, field := range app.Children {
+		if !field.IsSection() {
+			if target.Get(field.Key) == nil {
+				target.Set(field.Key, field.Value)
+			}
+			continue
+		}
+		section := target.Section(field.Key)
+		for _, item := range field.Children {
+			if existing := section.Get(item.Key); existing != nil {
+				*existing = *item
+			} else {
+				section.Children = append(section.Children, item)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := vdf.Write(out, to); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(dst+".tmp", dst)
+}
+
+// updateModsWithServer lists the mods in dedicated_server_mods_setup.lua
+// and lets the server download them with -only_update_server_mods
+func (m *Manager) updateModsWithServer(ids []string, job *jobHandle, logf func(string, ...interface{})) (map[string]modRecord, error) {
+	results := make(map[string]modRecord)
+	if err := m.AddMods(ids); err != nil {
+		return results, err
+	}
+	binPath, err := m.ServerBinary("")
+	if err != nil {
+		return results, err
+	}
+	if _, err := os.Stat(binPath); err != nil {
+		return results, fmt.Errorf("还没有安装服务端喵")
+	}
+	_, collections, _ := m.setupModIDs()
+	if len(collections) > 0 {
+		logf("%s 中还有 %d 个合集，服务端会一并更新喵", modsSetupFile, len(collections))
+	}
+
+	job.attempt(1)
+	ctx, cancel := context.WithTimeout(context.Background(), serverModsTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, binPath, "-only_update_server_mods")
+	// The server must run from its bin directory to find its data files
+	cmd.Dir = filepath.Dir(binPath)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return results, fmt.Errorf("无法运行服务端: %v", err)
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			job.logf("%s", line)
+		}
+	}
+	io.Copy(io.Discard, pr)
+	runErr := <-waitErr
+	if ctx.Err() != nil {
+		runErr = fmt.Errorf("超过 %v 还没有结束", serverModsTimeout)
+	}
+
+	// The server prints no per-mod result that is reliable to parse, the
+	// files it leaves behind are the result
+	for _, id := range ids {
+		_, _, ugc := readModInfo(m.ugcModDir(id))
+		_, _, legacy := readModInfo(m.legacyModDir(id))
+		var message string
+		switch {
+		case runErr != nil:
+			// Files from an earlier update may still be there
+			message = "服务端更新模组失败: " + runErr.Error()
+		case !ugc && !legacy:
+			message = "服务端没有下载这个模组，请确认模组 ID 正确且模组是公开的"
+		default:
+			logf("  ✓ %s", id)
+			results[id] = modRecord{Method: ModMethodServer, UpdatedAt: time.Now()}
+			continue
+		}
+		logf("  ✗ %s: %s", id, message)
+		results[id] = modRecord{Method: ModMethodServer, UpdatedAt: time.Now(), Error: message}
+	}
+	if runErr != nil {
+		return results, fmt.Errorf("服务端更新模组失败: %v", runErr)
+	}
+	return results, nil
+}
@@ -0,0 +1,260 @@
+package manager
+
+import (
+	"dst-manager/utils/procstat"
+	"sync"
+	"time"
+)
+
+const (
+	// monitorInterval is how often each running shard is sampled
+	// 资源采样间隔
+	monitorInterval = 10 * time.Second
+	// monitorHistory is how many samples are kept per shard, one hour at
+	// monitorInterval
+	// 每个世界保留的采样数
+	monitorHistory = 360
+	// maxResourceAlerts is how many alerts are kept in memory
+	maxResourceAlerts = 100
+)
+
+// ResourceSample is the resource usage of a shard process at one moment
+// 世界进程某一时刻的资源占用
+type ResourceSample struct {
+	Time time.Time `json:"time"`
+	PID  int       `json:"pid"`
+	// CPUPercent is relative to one core, like top: a busy shard using two
+	// cores shows 200
+	CPUPercent float64 `json:"cpu_percent"`
+	RSSBytes   int64   `json:"rss_bytes"`
+	Threads    int     `json:"threads"`
+	FDs        int     `json:"fds"`
+}
+
+// ShardResources is the current and recent resource usage of a shard
+// 世界当前及最近的资源占用
+type ShardResources struct {
+	Cluster string           `json:"cluster"`
+	Shard   string           `json:"shard"`
+	Current *ResourceSample  `json:"current,omitempty"`
+	History []ResourceSample `json:"history"`
+}
+
+// ResourceAlert records a shard going over a memory threshold
+// 内存超过阈值的记录
+type ResourceAlert struct {
+	Time       time.Time `json:"time"`
+	Cluster    string    `json:"cluster"`
+	Shard      string    `json:"shard"`
+	RSSBytes   int64     `json:"rss_bytes"`
+	LimitBytes int64     `json:"limit_bytes"`
+	// Action is "alert" or "restart"
+	Action string `json:"action"`
+}
+
+// resourceMonitor keeps the samples of every shard and the alerts raised
+// 保存各世界的采样数据和告警
+type resourceMonitor struct {
+	mu      sync.Mutex
+	samples map[string][]ResourceSample
+	// over counts the samples in a row above the lower threshold
+	over       map[string]int
+	alerted    map[string]bool
+	alerts     []ResourceAlert
+	restarting map[string]bool
+}
+
+func newResourceMonitor() *resourceMonitor {
+	return &resourceMonitor{
+		samples:    make(map[string][]ResourceSample),
+		over:       make(map[string]int),
+		alerted:    make(map[string]bool),
+		restarting: make(map[string]bool),
+	}
+}
+
+func (r *resourceMonitor) add(key string, sample ResourceSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	samples := append(r.samples[key], sample)
+	if len(samples) > monitorHistory {
+		samples = samples[len(samples)-monitorHistory:]
+	}
+	r.samples[key] = samples
+}
+
+func (r *resourceMonitor) history(key string) []ResourceSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ResourceSample{}, r.samples[key]...)
+}
+
+func (r *resourceMonitor) alert(alert ResourceAlert) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, alert)
+	if len(r.alerts) > maxResourceAlerts {
+		r.alerts = r.alerts[len(r.alerts)-maxResourceAlerts:]
+	}
+}
+
+// monitorShard samples a shard until it exits
+// 定期采样世界进程，直到进程退出
+func (m *Manager) monitorShard(shard *Shard) {
+	key := shardKey(shard.Cluster, shard.Name)
+	lastTime := shard.StartedAt()
+	var lastCPU time.Duration
+
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shard.Done():
+			m.monitor.mu.Lock()
+			delete(m.monitor.over, key)
+			delete(m.monitor.alerted, key)
+			m.monitor.mu.Unlock()
+			return
+		case now := <-ticker.C:
+			stat, err := procstat.Read(shard.PID())
+			if err != nil {
+				// Gone between the tick and the read; Done follows shortly
+				continue
+			}
+			sample := ResourceSample{
+				Time:     now,
+				PID:      shard.PID(),
+				RSSBytes: stat.RSSBytes,
+				Threads:  stat.Threads,
+				FDs:      stat.FDs,
+			}
+			if elapsed := now.Sub(lastTime); elapsed > 0 {
+				sample.CPUPercent = float64(stat.CPUTime-lastCPU) / float64(elapsed) * 100
+			}
+			lastTime, lastCPU = now, stat.CPUTime
+
+			m.monitor.add(key, sample)
+			m.checkMemory(shard, sample)
+		}
+	}
+}
+
+// checkMemory raises an alert or restarts the cluster when a shard stays
+// over its memory thresholds
+// 内存持续超过阈值时告警或重启存档
+func (m *Manager) checkMemory(shard *Shard, sample ResourceSample) {
+	if m.states.get(shard.Cluster, shard.Name).state != StateRunning {
+		return
+	}
+	settings, _ := m.LoadClusterSettings(shard.Cluster)
+	policy := settings.Resources
+	alertLimit := int64(policy.MemoryAlertMB) << 20
+	restartLimit := int64(policy.MemoryRestartMB) << 20
+	key := shardKey(shard.Cluster, shard.Name)
+
+	// Count from the lower of the two thresholds that is enabled
+	limit := alertLimit
+	if limit == 0 || (restartLimit > 0 && restartLimit < limit) {
+		limit = restartLimit
+	}
+	if limit == 0 {
+		return
+	}
+
+	m.monitor.mu.Lock()
+	if sample.RSSBytes <= limit {
+		delete(m.monitor.over, key)
+		delete(m.monitor.alerted, key)
+		m.monitor.mu.Unlock()
+		return
+	}
+	m.monitor.over[key]++
+	sustained := m.monitor.over[key] >= policy.SustainSamples
+	restart := sustained && restartLimit > 0 && sample.RSSBytes > restartLimit &&
+		!m.monitor.restarting[shard.Cluster]
+	alert := sustained && !restart && alertLimit > 0 && sample.RSSBytes > alertLimit &&
+		!m.monitor.alerted[key]
+	if restart {
+		m.monitor.restarting[shard.Cluster] = true
+	}
+	if alert {
+		m.monitor.alerted[key] = true
+	}
+	m.monitor.mu.Unlock()
+
+	switch {
+	case restart:
+		m.monitor.alert(ResourceAlert{
+			Time:       sample.Time,
+			Cluster:    shard.Cluster,
+			Shard:      shard.Name,
+			RSSBytes:   sample.RSSBytes,
+			LimitBytes: restartLimit,
+			Action:     "restart",
+		})
+		m.Log("%s 的内存占用 %d MB 超过了 %d MB，小花酱要重启存档 %s 了喵！",
+			key, sample.RSSBytes>>20, policy.MemoryRestartMB, shard.Cluster)
+		go func() {
+			defer func() {
+				m.monitor.mu.Lock()
+				delete(m.monitor.restarting, shard.Cluster)
+				m.monitor.mu.Unlock()
+			}()
+			grace := time.Duration(policy.RestartGraceSeconds) * time.Second
+			if err := m.RestartServer(shard.Cluster, grace); err != nil {
+				m.Log("因内存过高重启 %s 失败了喵: %v", shard.Cluster, err)
+			}
+		}()
+	case alert:
+		m.monitor.alert(ResourceAlert{
+			Time:       sample.Time,
+			Cluster:    shard.Cluster,
+			Shard:      shard.Name,
+			RSSBytes:   sample.RSSBytes,
+			LimitBytes: alertLimit,
+			Action:     "alert",
+		})
+		m.Log("注意喵！%s 的内存占用 %d MB 超过了告警线 %d MB", key, sample.RSSBytes>>20, policy.MemoryAlertMB)
+	}
+}
+
+// ShardResources returns the sampled resource usage of one shard. Current
+// is only set while the shard runs.
+// 返回单个世界的资源占用，只有运行中的世界才有当前值
+func (m *Manager) ShardResources(cluster, name string) ShardResources {
+	resources := ShardResources{
+		Cluster: cluster,
+		Shard:   name,
+		History: m.monitor.history(shardKey(cluster, name)),
+	}
+	if shard, ok := m.Supervisor.Get(cluster, name); ok && shard.Running() && len(resources.History) > 0 {
+		if last := resources.History[len(resources.History)-1]; last.PID == shard.PID() {
+			resources.Current = &last
+		}
+	}
+	return resources
+}
+
+// ClusterResources returns the resource usage of every shard of a cluster
+// 返回存档中所有世界的资源占用
+func (m *Manager) ClusterResources(cluster string) []ShardResources {
+	var resources []ShardResources
+	for _, name := range m.stopTargets(cluster) {
+		resources = append(resources, m.ShardResources(cluster, name))
+	}
+	return resources
+}
+
+// ResourceAlerts returns the memory alerts raised for a cluster
+// 返回存档的内存告警记录
+func (m *Manager) ResourceAlerts(cluster string) []ResourceAlert {
+	m.monitor.mu.Lock()
+	defer m.monitor.mu.Unlock()
+	alerts := []ResourceAlert{}
+	for _, alert := range m.monitor.alerts {
+		if alert.Cluster == cluster {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
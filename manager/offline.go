@@ -0,0 +1,251 @@
+package manager
+
+import (
+	"dst-manager/utils"
+	"dst-manager/utils/steamcmd"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serverBinaries are the executables a server tree must have, one of them
+var serverBinaries = []string{
+	filepath.Join("bin64", "dontstarve_dedicated_server_nullrenderer_x64"),
+	filepath.Join("bin", "dontstarve_dedicated_server_nullrenderer"),
+}
+
+// unpackArchive extracts a .tar.gz, .tgz or .tar file into dir
+func unpackArchive(archive, dir string) error {
+	name := strings.ToLower(archive)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		return steamcmd.ExtractTarGz(archive, dir)
+	case strings.HasSuffix(name, ".tar"):
+		f, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return steamcmd.ExtractTar(f, dir)
+	default:
+		return fmt.Errorf("不支持的安装包格式: %s (需要 .tar.gz、.tgz 或 .tar)", filepath.Base(archive))
+	}
+}
+
+// InstallSteamCMDFrom installs SteamCMD from a local steamcmd_linux.tar.gz,
+// for hosts that cannot reach Valve's CDN
+// 从本地的 steamcmd_linux.tar.gz 安装 SteamCMD，用于无法访问外网的机器
+func (m *Manager) InstallSteamCMDFrom(archive string) (err error) {
+	defer m.observeInstall("steamcmd", time.Now(), &err)
+	m.Log("正在从本地安装包 %s 安装 SteamCMD...", archive)
+	if _, err := os.Stat(archive); err != nil {
+		return fmt.Errorf("找不到 SteamCMD 安装包: %v", err)
+	}
+	if err := m.Config.EnsureDirs(); err != nil {
+		return err
+	}
+	options, _ := m.LoadInstallOptions()
+	if options.SteamCMDSHA256 != "" {
+		if err := steamcmd.VerifySHA256(archive, options.SteamCMDSHA256); err != nil {
+			m.Log("本地安装包与记录的校验和不一致喵，请确认安装包没有损坏，或在安装选项中更新 steamcmd_sha256")
+			return err
+		}
+	}
+	if err := unpackArchive(archive, m.Config.SteamCMDDir); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(m.Config.SteamCMDDir, "steamcmd.sh")); err != nil {
+		return fmt.Errorf("安装包中没有 steamcmd.sh")
+	}
+	m.Log("SteamCMD 安装成功啦！")
+	return nil
+}
+
+// InstallDSTFrom installs the server from a local archive or directory
+// holding a server tree, as SteamCMD would leave it on another machine
+// 从本地安装包或目录安装服务端（其他机器上用 SteamCMD 下载好的服务端）
+func (m *Manager) InstallDSTFrom(source string) error {
+	options, _ := m.LoadInstallOptions()
+	options.DSTArchive = source
+	return m.InstallDSTWith(*options)
+}
+
+// installOffline puts the server tree from source in place of the current
+// install. The tree is staged next to DSTInstallDir and only swapped in
+// once its binaries and appmanifest check out.
+func (m *Manager) installOffline(source string, options InstallOptions, job *jobHandle) (err error) {
+	defer m.observeInstall("dst", time.Now(), &err)
+	defer func() { job.finish(err) }()
+	logf := func(format string, a ...interface{}) {
+		m.Log(format, a...)
+		job.logf(format, a...)
+	}
+	defer func() {
+		if err != nil {
+			logf("离线安装失败了喵: %v", err)
+		}
+	}()
+	logf("正在从本地 %s 安装饥荒联机版服务端...", source)
+
+	stat, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("找不到服务端安装包: %v", err)
+	}
+
+	staging := m.Config.DSTInstallDir + ".offline"
+	os.RemoveAll(staging)
+	defer os.RemoveAll(staging)
+	if stat.IsDir() {
+		logf("正在复制服务端文件喵...")
+		if err := utils.CopyTree(source, staging); err != nil {
+			return fmt.Errorf("复制服务端文件失败: %v", err)
+		}
+	} else {
+		logf("正在解压服务端安装包喵...")
+		if err := os.MkdirAll(staging, 0755); err != nil {
+			return err
+		}
+		if err := unpackArchive(source, staging); err != nil {
+			return err
+		}
+	}
+
+	root, err := findServerRoot(staging)
+	if err != nil {
+		return err
+	}
+	info, err := readInstallInfo(root)
+	if err != nil {
+		return fmt.Errorf("读取安装包中的 appmanifest 失败: %v", err)
+	}
+	if !info.Installed || info.BuildID == "" {
+		return fmt.Errorf("安装包中没有 %s，无法确认服务端版本", filepath.Join("steamapps", filepath.Base(info.ManifestPath)))
+	}
+	if !info.FullyInstalled() {
+		logf("注意：安装包中的 appmanifest 显示安装没有完成 (%s) 喵", strings.Join(info.State, ", "))
+	}
+	if !strings.EqualFold(info.Branch, options.BranchName()) {
+		logf("注意：安装包是 %s 分支，安装选项中是 %s 分支喵", info.Branch, options.BranchName())
+	}
+
+	// Keep the build being replaced so clusters can be pinned to it; the
+	// old tree is moved there after the swap instead of being copied
+	kept, keepOld := m.installToKeep(options.KeepVersions, info.BuildID)
+
+	logf("正在保留当前安装中的模组喵...")
+	if err := carryOverMods(m.Config.DSTInstallDir, root); err != nil {
+		return fmt.Errorf("保留模组失败: %v", err)
+	}
+
+	old := m.Config.DSTInstallDir + ".old"
+	os.RemoveAll(old)
+	if err := os.Rename(m.Config.DSTInstallDir, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(root, m.Config.DSTInstallDir); err != nil {
+		os.Rename(old, m.Config.DSTInstallDir)
+		return err
+	}
+	if keepOld {
+		if err := m.keepInstall(old, kept, options.KeepVersions, utils.MoveTree); err != nil {
+			logf("保留旧版本失败了喵: %v", err)
+		}
+	}
+	os.RemoveAll(old)
+
+	logf("已安装版本 %s (分支 %s)", info.BuildID, info.Branch)
+	err = m.recordInstalledBuild(InstalledBuild{
+		Branch:      info.Branch,
+		BuildID:     info.BuildID,
+		InstalledAt: time.Now(),
+	})
+	if err != nil {
+		logf("记录已安装版本失败了喵: %v", err)
+	}
+	logf("饥荒联机版服务端离线安装完成！可以开始冒险了喵！")
+	return nil
+}
+
+// modDirs hold the mods downloaded into an install rather than files of
+// the server itself
+var modDirs = []string{"mods", "ugc_mods"}
+
+// carryOverMods copies the mods of the current install into the staged
+// tree, so dedicated_server_mods_setup.lua, workshop-* and ugc_mods
+// survive the swap; the current install's entries win over the archive's
+func carryOverMods(current, root string) error {
+	for _, dir := range modDirs {
+		entries, err := os.ReadDir(filepath.Join(current, dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			dst := filepath.Join(root, dir, entry.Name())
+			if err := os.RemoveAll(dst); err != nil {
+				return err
+			}
+			if err := utils.CopyTree(filepath.Join(current, dir, entry.Name()), dst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findServerRoot finds the server tree in dir, which is either dir itself
+// or a folder up to two levels down, as archives often wrap the tree
+func findServerRoot(dir string) (string, error) {
+	candidates := []string{dir}
+	for depth := 0; depth < 2; depth++ {
+		var next []string
+		for _, candidate := range candidates {
+			if ok, err := hasServerBinary(candidate); ok {
+				return candidate, nil
+			} else if err != nil {
+				return "", err
+			}
+			entries, _ := os.ReadDir(candidate)
+			for _, entry := range entries {
+				if entry.IsDir() {
+					next = append(next, filepath.Join(candidate, entry.Name()))
+				}
+			}
+		}
+		candidates = next
+	}
+	for _, candidate := range candidates {
+		if ok, err := hasServerBinary(candidate); ok {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("安装包中没有找到服务端程序 (%s)", strings.Join(serverBinaries, " 或 "))
+}
+
+// hasServerBinary reports whether dir holds one of the server binaries.
+// A binary that is there but not executable is an error, not a miss.
+func hasServerBinary(dir string) (bool, error) {
+	for _, binary := range serverBinaries {
+		info, err := os.Stat(filepath.Join(dir, binary))
+		if err != nil {
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			return false, fmt.Errorf("%s 不是普通文件", binary)
+		}
+		if info.Mode().Perm()&0111 == 0 {
+			return false, fmt.Errorf("%s 没有执行权限，安装包可能在打包时丢失了文件权限", binary)
+		}
+		return true, nil
+	}
+	return false, nil
+}
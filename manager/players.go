@@ -0,0 +1,282 @@
+package manager
+
+import (
+	"dst-manager/utils/clusterUtils"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// playerSyncInterval is how often the online list of a running shard is
+	// checked against c_listallplayers()
+	// 定期用 c_listallplayers() 校对在线列表的间隔
+	playerSyncInterval = 2 * time.Minute
+	// playerListTimeout is how long a shard may take to print its player list
+	// 等待世界输出玩家列表的时间
+	playerListTimeout = 5 * time.Second
+	// playerListEnd is printed after c_listallplayers(), so an empty list can
+	// be told apart from a shard that did not answer
+	playerListEnd = "[dst-manager] player list end"
+)
+
+// OnlinePlayer is a player connected to one of a cluster's shards
+// 在线玩家
+type OnlinePlayer struct {
+	KUID        string    `json:"ku_id,omitempty"`
+	Name        string    `json:"name"`
+	Character   string    `json:"character,omitempty"`
+	Shard       string    `json:"shard"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// playerTracker keeps the online players of every cluster
+// 记录每个存档的在线玩家
+type playerTracker struct {
+	mu       sync.Mutex
+	clusters map[string][]*OnlinePlayer
+}
+
+func newPlayerTracker() *playerTracker {
+	return &playerTracker{clusters: make(map[string][]*OnlinePlayer)}
+}
+
+// find returns the player with the KU ID, or with the name when the KU ID
+// is unknown on either side
+func (t *playerTracker) find(cluster, kuid, name string) *OnlinePlayer {
+	for _, p := range t.clusters[cluster] {
+		if kuid != "" && p.KUID == kuid {
+			return p
+		}
+		if (kuid == "" || p.KUID == "") && p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// connect records a player on a shard. A player already online elsewhere
+// in the cluster has migrated and keeps its connect time.
+func (t *playerTracker) connect(cluster, shard, kuid, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p := t.find(cluster, kuid, name); p != nil {
+		p.Shard, p.Name = shard, name
+		if kuid != "" {
+			p.KUID = kuid
+		}
+		return
+	}
+	t.clusters[cluster] = append(t.clusters[cluster], &OnlinePlayer{
+		KUID:        kuid,
+		Name:        name,
+		Shard:       shard,
+		ConnectedAt: time.Now(),
+	})
+}
+
+// join records a join announcement; announcements are broadcast to every
+// shard, so a known player stays where it is
+func (t *playerTracker) join(cluster, shard, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.find(cluster, "", name) != nil {
+		return
+	}
+	t.clusters[cluster] = append(t.clusters[cluster], &OnlinePlayer{
+		Name:        name,
+		Shard:       shard,
+		ConnectedAt: time.Now(),
+	})
+}
+
+func (t *playerTracker) character(cluster, name, character string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p := t.find(cluster, "", name); p != nil {
+		p.Character = character
+	}
+}
+
+func (t *playerTracker) leave(cluster, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(cluster, func(p *OnlinePlayer) bool { return p.Name == name })
+}
+
+func (t *playerTracker) clearShard(cluster, shard string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remove(cluster, func(p *OnlinePlayer) bool { return p.Shard == shard })
+}
+
+// replaceShard makes the players of a shard match a c_listallplayers() list
+func (t *playerTracker) replaceShard(cluster, shard string, listed []clusterUtils.ListedPlayer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	present := make(map[*OnlinePlayer]bool)
+	for _, l := range listed {
+		p := t.find(cluster, l.KUID, l.Name)
+		if p == nil {
+			p = &OnlinePlayer{ConnectedAt: time.Now()}
+			t.clusters[cluster] = append(t.clusters[cluster], p)
+		}
+		p.KUID, p.Name, p.Character, p.Shard = l.KUID, l.Name, l.Character, shard
+		present[p] = true
+	}
+	t.remove(cluster, func(p *OnlinePlayer) bool { return p.Shard == shard && !present[p] })
+}
+
+func (t *playerTracker) remove(cluster string, match func(*OnlinePlayer) bool) {
+	kept := t.clusters[cluster][:0]
+	for _, p := range t.clusters[cluster] {
+		if !match(p) {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == 0 {
+		delete(t.clusters, cluster)
+		return
+	}
+	t.clusters[cluster] = kept
+}
+
+func (t *playerTracker) list(cluster string) []OnlinePlayer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	players := make([]OnlinePlayer, 0, len(t.clusters[cluster]))
+	for _, p := range t.clusters[cluster] {
+		players = append(players, *p)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].ConnectedAt.Before(players[j].ConnectedAt)
+	})
+	return players
+}
+
+// followPlayers updates the online list from a shard's output until the
+// shard exits, and periodically checks it against c_listallplayers()
+// 根据世界输出更新在线列表，并定期用 c_listallplayers() 校对
+func (m *Manager) followPlayers(shard *Shard, lines <-chan string, unsubscribe func()) {
+	defer unsubscribe()
+	ticker := time.NewTicker(playerSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				m.players.clearShard(shard.Cluster, shard.Name)
+				return
+			}
+			m.playerLine(shard, line)
+		case <-ticker.C:
+			go m.syncShardPlayers(shard)
+		}
+	}
+}
+
+func (m *Manager) playerLine(shard *Shard, line string) {
+	if name, identity, ok := clusterUtils.ParsePlayerIdentityLine(line); ok {
+		if identity.KUID != "" {
+			m.players.connect(shard.Cluster, shard.Name, identity.KUID, name)
+		} else {
+			m.players.character(shard.Cluster, name, identity.Character)
+		}
+		return
+	}
+	if entry, ok := clusterUtils.ParseChatLine(line); ok {
+		switch entry.Kind {
+		case clusterUtils.ChatJoin:
+			m.players.join(shard.Cluster, shard.Name, entry.Player)
+		case clusterUtils.ChatLeave:
+			m.players.leave(shard.Cluster, entry.Player)
+		}
+	}
+}
+
+// syncShardPlayers asks a shard for its players and replaces what the logs
+// said about that shard with the answer. Shards that are starting or
+// stopping are skipped; the command is recorded in the console audit log.
+// 向世界查询玩家列表，并以此为准更新在线列表
+func (m *Manager) syncShardPlayers(shard *Shard) (err error) {
+	if m.states.get(shard.Cluster, shard.Name).state != StateRunning {
+		return fmt.Errorf("%s/%s 还没有就绪", shard.Cluster, shard.Name)
+	}
+	lines, unsubscribe := shard.Subscribe()
+	defer unsubscribe()
+
+	command := `c_listallplayers() print("` + playerListEnd + `")`
+	var output []string
+	defer func() {
+		m.audit(systemOperator, shard.Cluster, shard.Name, command, output, err)
+	}()
+	if err := shard.SendCommand(command); err != nil {
+		return err
+	}
+
+	var listed []clusterUtils.ListedPlayer
+	timeout := time.After(playerListTimeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return fmt.Errorf("%s/%s 已经退出了", shard.Cluster, shard.Name)
+			}
+			if strings.HasSuffix(line, playerListEnd) {
+				m.players.replaceShard(shard.Cluster, shard.Name, listed)
+				return nil
+			}
+			if player, ok := clusterUtils.ParsePlayerListLine(line); ok {
+				listed = append(listed, player)
+				output = append(output, line)
+			}
+		case <-timeout:
+			return fmt.Errorf("%s/%s 没有回应玩家列表查询", shard.Cluster, shard.Name)
+		}
+	}
+}
+
+// SyncPlayers checks the online list of a cluster against
+// c_listallplayers() on each of its running shards
+// 用各世界的 c_listallplayers() 校对存档的在线列表
+func (m *Manager) SyncPlayers(cluster string) error {
+	var shards []*Shard
+	for _, name := range m.RunningShards(cluster) {
+		if m.states.get(cluster, name).state != StateRunning {
+			continue
+		}
+		if shard, ok := m.Supervisor.Get(cluster, name); ok {
+			shards = append(shards, shard)
+		}
+	}
+	if len(shards) == 0 {
+		return fmt.Errorf("存档 %s 没有就绪的世界", cluster)
+	}
+
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, shard *Shard) {
+			defer wg.Done()
+			errs[i] = m.syncShardPlayers(shard)
+		}(i, shard)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnlinePlayers returns the players online in a cluster, earliest first
+// 返回存档的在线玩家，按连接时间排序
+func (m *Manager) OnlinePlayers(cluster string) []OnlinePlayer {
+	return m.players.list(cluster)
+}
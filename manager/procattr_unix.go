@@ -0,0 +1,12 @@
+//go:build !windows
+
+package manager
+
+import "syscall"
+
+// shardProcAttr puts shards in their own process group so that Ctrl+C in
+// the menu does not kill them without saving
+// 让世界进程独立成组，避免在菜单里按 Ctrl+C 时未保存就被杀掉
+func shardProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
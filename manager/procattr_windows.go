@@ -0,0 +1,11 @@
+//go:build windows
+
+package manager
+
+import "syscall"
+
+// shardProcAttr has nothing to set on Windows
+// Windows 下无需额外设置
+func shardProcAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,484 @@
+package manager
+
+import (
+	"bufio"
+	"crypto/rand"
+	"dst-manager/utils/cron"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	schedulesFile       = "schedules.json"
+	scheduleHistoryFile = "schedule_history.log"
+
+	// schedulerTick is how often due schedules are checked
+	schedulerTick = 20 * time.Second
+	// A due time older than this when it is noticed was missed while the
+	// manager was down
+	missedAfter = 2 * time.Minute
+)
+
+// ScheduleAction is what a schedule does when it fires
+// 定时任务的动作类型
+type ScheduleAction string
+
+const (
+	ActionRestart  ScheduleAction = "restart"
+	ActionBackup   ScheduleAction = "backup"
+	ActionConsole  ScheduleAction = "console"
+	ActionAnnounce ScheduleAction = "announce"
+)
+
+// Schedule is a persisted cron job that runs a manager action
+// 持久化的定时任务
+type Schedule struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Cron     string         `json:"cron"`
+	Timezone string         `json:"timezone"`
+	Action   ScheduleAction `json:"action"`
+	Cluster  string         `json:"cluster"`
+	// Shard for console commands, the master shard when empty
+	Shard   string `json:"shard,omitempty"`
+	Command string `json:"command,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Countdown before a restart, announced to the players
+	GraceSeconds int  `json:"grace_seconds,omitempty"`
+	Enabled      bool `json:"enabled"`
+	// Run once after a restart of the manager if a run was missed
+	CatchUp bool `json:"catch_up"`
+
+	CreatedAt time.Time `json:"created_at"`
+	// LastDue is the scheduled time of the last run, executed or skipped
+	LastDue time.Time `json:"last_due,omitempty"`
+	NextRun time.Time `json:"next_run,omitempty"`
+}
+
+// ScheduleRun is one entry of the run history
+// 定时任务的执行记录
+type ScheduleRun struct {
+	ScheduleID   string         `json:"schedule_id"`
+	Name         string         `json:"name"`
+	Action       ScheduleAction `json:"action"`
+	Cluster      string         `json:"cluster"`
+	ScheduledFor time.Time      `json:"scheduled_for"`
+	StartedAt    time.Time      `json:"started_at"`
+	FinishedAt   time.Time      `json:"finished_at"`
+	CatchUp      bool           `json:"catch_up"`
+	Success      bool           `json:"success"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// Scheduler runs persisted schedules in the background
+// 定时任务调度器
+type Scheduler struct {
+	m *Manager
+
+	mu        sync.Mutex
+	schedules []*Schedule
+	running   map[string]bool
+	loaded    bool
+	started   bool
+}
+
+func newScheduler(m *Manager) *Scheduler {
+	return &Scheduler{
+		m:       m,
+		running: make(map[string]bool),
+	}
+}
+
+// Validate checks a schedule before it is stored
+// 校验定时任务
+func (sc *Schedule) Validate() error {
+	if _, err := cron.Parse(sc.Cron); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(sc.Timezone); err != nil {
+		return fmt.Errorf("无效的时区 %q: %v", sc.Timezone, err)
+	}
+	if sc.Cluster == "" {
+		return errors.New("存档名不能为空")
+	}
+	switch sc.Action {
+	case ActionRestart, ActionBackup:
+	case ActionConsole:
+		if sc.Command == "" {
+			return errors.New("控制台任务需要填写 command")
+		}
+	case ActionAnnounce:
+		if sc.Message == "" {
+			return errors.New("公告任务需要填写 message")
+		}
+	default:
+		return fmt.Errorf("未知的动作: %q", sc.Action)
+	}
+	return nil
+}
+
+// next returns the first due time after t in the schedule's timezone
+func (sc *Schedule) next(t time.Time) time.Time {
+	expr, err := cron.Parse(sc.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(sc.Timezone)
+	if err != nil {
+		return time.Time{}
+	}
+	return expr.Next(t.In(loc))
+}
+
+// Start loads the schedules and begins checking them in the background
+// 加载定时任务并开始后台调度
+func (s *Scheduler) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return nil
+	}
+	if err := s.ensureLoaded(); err != nil {
+		return err
+	}
+	s.started = true
+
+	go func() {
+		s.tick(time.Now())
+		ticker := time.NewTicker(schedulerTick)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.tick(now)
+		}
+	}()
+	return nil
+}
+
+// tick runs every schedule that became due. Several missed due times only
+// cause one run, and runs missed while the manager was down only happen
+// for schedules with CatchUp.
+func (s *Scheduler) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, sc := range s.schedules {
+		if !sc.Enabled {
+			continue
+		}
+		base := sc.LastDue
+		if base.IsZero() {
+			base = sc.CreatedAt
+		}
+
+		due := sc.next(base)
+		if due.IsZero() || due.After(now) {
+			continue
+		}
+		// Skip ahead to the latest due time that has passed
+		for {
+			following := sc.next(due)
+			if following.IsZero() || following.After(now) {
+				break
+			}
+			due = following
+		}
+
+		sc.LastDue = due
+		sc.NextRun = sc.next(due)
+		changed = true
+
+		catchUp := now.Sub(due) > missedAfter
+		if catchUp && !sc.CatchUp {
+			continue
+		}
+		if s.running[sc.ID] {
+			s.m.Log("定时任务 %s 上一次还没跑完，这次先跳过喵", sc.Name)
+			continue
+		}
+		s.running[sc.ID] = true
+		go s.run(*sc, due, catchUp)
+	}
+	if changed {
+		if err := s.save(); err != nil {
+			s.m.Log("保存定时任务失败了喵: %v", err)
+		}
+	}
+}
+
+func (s *Scheduler) run(sc Schedule, due time.Time, catchUp bool) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, sc.ID)
+		s.mu.Unlock()
+	}()
+
+	record := ScheduleRun{
+		ScheduleID:   sc.ID,
+		Name:         sc.Name,
+		Action:       sc.Action,
+		Cluster:      sc.Cluster,
+		ScheduledFor: due,
+		StartedAt:    time.Now(),
+		CatchUp:      catchUp,
+	}
+	if catchUp {
+		s.m.Log("补跑错过的定时任务 %s (原定 %s) 喵~", sc.Name, due.Format("2006-01-02 15:04"))
+	} else {
+		s.m.Log("开始执行定时任务 %s 喵~", sc.Name)
+	}
+
+	err := s.m.runScheduleAction(sc)
+	record.FinishedAt = time.Now()
+	record.Success = err == nil
+	if err != nil {
+		record.Error = err.Error()
+		s.m.Log("定时任务 %s 失败了喵: %v", sc.Name, err)
+	}
+	if err := s.appendHistory(record); err != nil {
+		s.m.Log("写入定时任务记录失败了喵: %v", err)
+	}
+}
+
+// runScheduleAction performs the action of a schedule
+// 执行定时任务的动作
+func (m *Manager) runScheduleAction(sc Schedule) error {
+	switch sc.Action {
+	case ActionRestart:
+		if !m.IsRunning(sc.Cluster) {
+			return fmt.Errorf("存档 %s 没有在运行，跳过重启", sc.Cluster)
+		}
+		return m.RestartServer(sc.Cluster, time.Duration(sc.GraceSeconds)*time.Second)
+	case ActionBackup:
+		return m.BackupCluster(sc.Cluster, BackupFileName(sc.Cluster, time.Now()))
+	case ActionConsole:
+		return m.scheduleConsole(sc, sc.Command)
+	case ActionAnnounce:
+		return m.Announce(sc.Cluster, sc.Message)
+	}
+	return fmt.Errorf("未知的动作: %q", sc.Action)
+}
+
+func (m *Manager) scheduleConsole(sc Schedule, command string) error {
+	shard := sc.Shard
+	if shard == "" {
+		shards, err := m.ListShards(sc.Cluster)
+		if err != nil {
+			return err
+		}
+		shard = shards[0].Name
+	}
+	_, err := m.SendConsoleAs("scheduler:"+sc.Name, sc.Cluster, shard, command)
+	return err
+}
+
+// List returns a copy of all schedules sorted by name
+// 列出所有定时任务
+func (s *Scheduler) List() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ensureLoaded()
+	list := []Schedule{}
+	for _, sc := range s.schedules {
+		list = append(list, *sc)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Get returns one schedule by id
+// 按 ID 获取定时任务
+func (s *Scheduler) Get(id string) (Schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ensureLoaded()
+	if sc := s.find(id); sc != nil {
+		return *sc, true
+	}
+	return Schedule{}, false
+}
+
+// Create validates and stores a new schedule
+// 新建定时任务
+func (s *Scheduler) Create(sc Schedule) (Schedule, error) {
+	if sc.Timezone == "" {
+		sc.Timezone = "Local"
+	}
+	if err := sc.Validate(); err != nil {
+		return Schedule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ensureLoaded(); err != nil {
+		return Schedule{}, err
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	sc.ID = hex.EncodeToString(id)
+	sc.CreatedAt = time.Now()
+	sc.LastDue = time.Time{}
+	sc.NextRun = sc.next(sc.CreatedAt)
+	if sc.Name == "" {
+		sc.Name = string(sc.Action) + "-" + sc.ID[:6]
+	}
+
+	s.schedules = append(s.schedules, &sc)
+	return sc, s.save()
+}
+
+// Update replaces the definition of a schedule, keeping its run state
+// 修改定时任务
+func (s *Scheduler) Update(id string, update Schedule) (Schedule, error) {
+	if update.Timezone == "" {
+		update.Timezone = "Local"
+	}
+	if err := update.Validate(); err != nil {
+		return Schedule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ensureLoaded(); err != nil {
+		return Schedule{}, err
+	}
+
+	sc := s.find(id)
+	if sc == nil {
+		return Schedule{}, fmt.Errorf("找不到定时任务 %s", id)
+	}
+	update.ID = sc.ID
+	update.CreatedAt = sc.CreatedAt
+	update.LastDue = sc.LastDue
+	if update.Cron != sc.Cron || update.Timezone != sc.Timezone {
+		// Do not replay the old timetable under the new expression
+		update.LastDue = time.Now()
+	}
+	update.NextRun = update.next(time.Now())
+	*sc = update
+	return *sc, s.save()
+}
+
+// Delete removes a schedule
+// 删除定时任务
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.ensureLoaded(); err != nil {
+		return err
+	}
+
+	for i, sc := range s.schedules {
+		if sc.ID == id {
+			s.schedules = append(s.schedules[:i], s.schedules[i+1:]...)
+			return s.save()
+		}
+	}
+	return fmt.Errorf("找不到定时任务 %s", id)
+}
+
+// History returns the most recent runs, newest last. An empty id returns
+// the runs of every schedule.
+// 返回最近的执行记录（id 为空时返回全部）
+func (s *Scheduler) History(id string, limit int) ([]ScheduleRun, error) {
+	f, err := os.Open(filepath.Join(s.m.Config.DataDir, scheduleHistoryFile))
+	if os.IsNotExist(err) {
+		return []ScheduleRun{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	runs := []ScheduleRun{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var run ScheduleRun
+		if json.Unmarshal(scanner.Bytes(), &run) != nil {
+			continue
+		}
+		if id != "" && run.ScheduleID != id {
+			continue
+		}
+		runs = append(runs, run)
+	}
+	if limit > 0 && len(runs) > limit {
+		runs = runs[len(runs)-limit:]
+	}
+	return runs, scanner.Err()
+}
+
+func (s *Scheduler) find(id string) *Schedule {
+	for _, sc := range s.schedules {
+		if sc.ID == id {
+			return sc
+		}
+	}
+	return nil
+}
+
+// ensureLoaded reads the schedules file once, so API calls made before
+// Start never overwrite it with an empty list
+func (s *Scheduler) ensureLoaded() error {
+	if s.loaded {
+		return nil
+	}
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+func (s *Scheduler) load() error {
+	data, err := os.ReadFile(filepath.Join(s.m.Config.DataDir, schedulesFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.schedules); err != nil {
+		return fmt.Errorf("解析 %s 失败: %v", schedulesFile, err)
+	}
+	return nil
+}
+
+func (s *Scheduler) save() error {
+	if err := os.MkdirAll(s.m.Config.DataDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.schedules, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename so a crash never leaves a half written file
+	path := filepath.Join(s.m.Config.DataDir, schedulesFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *Scheduler) appendHistory(run ScheduleRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.m.Config.DataDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.m.Config.DataDir, scheduleHistoryFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(run)
+}
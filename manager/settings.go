@@ -0,0 +1,144 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// settingsFile is the per-cluster manager settings file, kept inside the
+// cluster directory so it travels with backups
+// 存档级管理设置文件，放在存档目录里随备份一起走
+const settingsFile = "dst-manager.json"
+
+// ClusterSettings holds manager options for one cluster
+// 单个存档的管理设置
+type ClusterSettings struct {
+	// Branch is the server branch the cluster needs, such as updatebeta;
+	// empty accepts whatever is installed
+	Branch string `json:"branch,omitempty"`
+	// Install pins the cluster to a kept server build by id; empty runs
+	// the current install
+	Install   string            `json:"install,omitempty"`
+	Restart   RestartPolicy     `json:"restart"`
+	Shutdown  ShutdownPolicy    `json:"shutdown"`
+	Startup   StartupPolicy     `json:"startup"`
+	Countdown CountdownSettings `json:"countdown"`
+	Resources ResourcePolicy    `json:"resources"`
+}
+
+// RestartPolicy controls how the watchdog restarts crashed shards
+// 崩溃自动重启策略
+type RestartPolicy struct {
+	Enabled bool `json:"enabled"`
+	// First delay before restarting, doubled after every crash
+	InitialDelaySeconds int `json:"initial_delay_seconds"`
+	MaxDelaySeconds     int `json:"max_delay_seconds"`
+	// Give up after MaxRestarts crashes within WindowSeconds
+	MaxRestarts   int `json:"max_restarts"`
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// ShutdownPolicy controls how long a stop waits before forcing shards down
+// 停止服务器时的等待策略
+type ShutdownPolicy struct {
+	// How long to wait for the save and exit after c_shutdown(true)
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// How long to wait after SIGTERM before sending SIGKILL
+	TermGraceSeconds int `json:"term_grace_seconds"`
+}
+
+// StartupPolicy controls how long a start waits for each shard to be ready
+// 启动时等待世界就绪的策略
+type StartupPolicy struct {
+	// World generation on first start can take several minutes
+	ReadyTimeoutSeconds int `json:"ready_timeout_seconds"`
+}
+
+// CountdownSettings controls the announcements sent before a stop or
+// restart. {time} in a message is replaced by the remaining time.
+// 停止/重启前的倒计时公告，消息中的 {time} 会替换为剩余时间
+type CountdownSettings struct {
+	// Remaining seconds at which to announce, besides the start
+	PointsSeconds  []int  `json:"points_seconds"`
+	StopMessage    string `json:"stop_message"`
+	RestartMessage string `json:"restart_message"`
+	UpdateMessage  string `json:"update_message"`
+	CancelMessage  string `json:"cancel_message"`
+}
+
+// ResourcePolicy sets the memory thresholds checked by the resource
+// monitor. A threshold of 0 is disabled.
+// 资源监控的内存阈值，为 0 时不启用
+type ResourcePolicy struct {
+	// Log an alert when a shard's memory stays above this many MB
+	MemoryAlertMB int `json:"memory_alert_mb"`
+	// Restart the cluster when a shard's memory stays above this many MB
+	MemoryRestartMB int `json:"memory_restart_mb"`
+	// How many samples in a row must be over a threshold before acting
+	SustainSamples int `json:"sustain_samples"`
+	// Countdown announced before a memory restart
+	RestartGraceSeconds int `json:"restart_grace_seconds"`
+}
+
+// DefaultClusterSettings returns the settings used when a cluster has no file
+// 默认设置
+func DefaultClusterSettings() *ClusterSettings {
+	return &ClusterSettings{
+		Restart: RestartPolicy{
+			Enabled:             true,
+			InitialDelaySeconds: 5,
+			MaxDelaySeconds:     300,
+			MaxRestarts:         5,
+			WindowSeconds:       600,
+		},
+		Shutdown: ShutdownPolicy{
+			TimeoutSeconds:   120,
+			TermGraceSeconds: 15,
+		},
+		Startup: StartupPolicy{
+			ReadyTimeoutSeconds: 600,
+		},
+		Countdown: CountdownSettings{
+			PointsSeconds:  []int{300, 60, 10},
+			StopMessage:    "服务器将在 {time}后关闭，请尽快找安全的地方下线~",
+			RestartMessage: "服务器将在 {time}后重启，请稍后重新连接~",
+			UpdateMessage:  "游戏有新版本啦，服务器将在 {time}后停止并更新，请稍后重新连接~",
+			CancelMessage:  "服务器关闭已取消，继续冒险吧！",
+		},
+		Resources: ResourcePolicy{
+			SustainSamples:      3,
+			RestartGraceSeconds: 300,
+		},
+	}
+}
+
+// LoadClusterSettings reads the settings of a cluster, falling back to
+// defaults for a missing file or missing fields
+// 读取存档设置，文件或字段缺失时使用默认值
+func (m *Manager) LoadClusterSettings(cluster string) (*ClusterSettings, error) {
+	settings := DefaultClusterSettings()
+
+	data, err := os.ReadFile(filepath.Join(m.Config.ClusterDir, cluster, settingsFile))
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return DefaultClusterSettings(), fmt.Errorf("解析 %s 失败: %v", settingsFile, err)
+	}
+	return settings, nil
+}
+
+// SaveClusterSettings writes the settings of a cluster
+// 保存存档设置
+func (m *Manager) SaveClusterSettings(cluster string, settings *ClusterSettings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.Config.ClusterDir, cluster, settingsFile), data, 0644)
+}
@@ -0,0 +1,68 @@
+package manager
+
+import (
+	"dst-manager/utils/clusterUtils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ShardInfo describes a shard folder found in a cluster directory
+// 存档目录中发现的世界
+type ShardInfo struct {
+	Name     string `json:"name"`
+	IsMaster bool   `json:"is_master"`
+}
+
+// ListShards scans a cluster for subfolders containing server.ini. The
+// master shard comes first, the others follow by name.
+// 扫描存档目录中带 server.ini 的子目录，主世界排在最前
+func (m *Manager) ListShards(cluster string) ([]ShardInfo, error) {
+	clusterPath := filepath.Join(m.Config.ClusterDir, cluster)
+	entries, err := os.ReadDir(clusterPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取存档目录失败: %v", err)
+	}
+
+	var shards []ShardInfo
+	masters := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		ini, err := clusterUtils.ReadIni(filepath.Join(clusterPath, entry.Name(), "server.ini"))
+		if err != nil {
+			continue
+		}
+		shard := ShardInfo{
+			Name:     entry.Name(),
+			IsMaster: strings.EqualFold(strings.TrimSpace(ini["SHARD"]["is_master"]), "true"),
+		}
+		if shard.IsMaster {
+			masters++
+		}
+		shards = append(shards, shard)
+	}
+
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("存档 %s 里没有找到任何带 server.ini 的世界", cluster)
+	}
+	// A lone shard without a [SHARD] section is its own master
+	if len(shards) == 1 {
+		shards[0].IsMaster = true
+		masters = 1
+	}
+	if masters != 1 {
+		return nil, fmt.Errorf("存档 %s 需要且只能有一个 is_master = true 的世界 (找到 %d 个)", cluster, masters)
+	}
+
+	sort.Slice(shards, func(i, j int) bool {
+		if shards[i].IsMaster != shards[j].IsMaster {
+			return shards[i].IsMaster
+		}
+		return shards[i].Name < shards[j].Name
+	})
+	return shards, nil
+}
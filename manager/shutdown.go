@@ -0,0 +1,97 @@
+package manager
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// StopOutcome describes how a shard ended when it was stopped
+// 世界停止的方式
+type StopOutcome string
+
+const (
+	StopNotRunning StopOutcome = "not_running"
+	StopGraceful   StopOutcome = "graceful"
+	StopTerminated StopOutcome = "terminated"
+	StopKilled     StopOutcome = "killed"
+	StopFailed     StopOutcome = "failed"
+)
+
+// StopResult reports how one shard was stopped
+// 单个世界的停止结果
+type StopResult struct {
+	Cluster  string        `json:"cluster"`
+	Shard    string        `json:"shard"`
+	Outcome  StopOutcome   `json:"outcome"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// killWait is how long to wait for the process to vanish after SIGKILL
+const killWait = 5 * time.Second
+
+// saveFinished reports whether a log line means the shard has saved and is exiting
+// 日志行是否表示存档已保存、正在退出
+func saveFinished(line string) bool {
+	return strings.Contains(line, "Shutting down")
+}
+
+// awaitShutdown waits for a shard that was sent c_shutdown(true) to exit,
+// escalating to SIGTERM and then SIGKILL when it hangs
+// 等待已发送 c_shutdown(true) 的世界退出，卡住时依次发送 SIGTERM 和 SIGKILL
+func awaitShutdown(shard *Shard, lines <-chan string, policy ShutdownPolicy) (StopOutcome, error) {
+	grace := time.Duration(policy.TermGraceSeconds) * time.Second
+	deadline := time.NewTimer(time.Duration(policy.TimeoutSeconds) * time.Second)
+	defer deadline.Stop()
+
+	saved := false
+wait:
+	for {
+		select {
+		case <-shard.Done():
+			return StopGraceful, nil
+		case line, ok := <-lines:
+			if !ok {
+				lines = nil
+				continue
+			}
+			// Once the save is done there is no reason to wait the full timeout
+			if !saved && saveFinished(line) {
+				saved = true
+				if !deadline.Stop() {
+					<-deadline.C
+				}
+				deadline.Reset(grace)
+			}
+		case <-deadline.C:
+			break wait
+		}
+	}
+
+	if err := shard.Signal(syscall.SIGTERM); err != nil {
+		return StopFailed, err
+	}
+	if waitExit(shard, grace) {
+		return StopTerminated, nil
+	}
+
+	if err := shard.Signal(os.Kill); err != nil {
+		return StopFailed, err
+	}
+	if waitExit(shard, killWait) {
+		return StopKilled, nil
+	}
+	return StopFailed, nil
+}
+
+// waitExit waits up to d for the shard to exit
+func waitExit(shard *Shard, d time.Duration) bool {
+	select {
+	case <-shard.Done():
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
@@ -0,0 +1,142 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Typed startup failures, use errors.Is to tell them apart
+// 启动失败的错误类型，可用 errors.Is 判断
+var (
+	ErrInvalidToken = errors.New("cluster token 无效或缺失")
+	ErrPortInUse    = errors.New("端口已被占用")
+	ErrShardExited  = errors.New("世界在就绪前退出了")
+	ErrStartTimeout = errors.New("等待世界就绪超时")
+)
+
+// StartupError reports which shard failed to start and the log line that
+// gave it away
+// 启动失败的世界以及对应的日志行
+type StartupError struct {
+	Cluster string
+	Shard   string
+	Err     error
+	Line    string
+}
+
+func (e *StartupError) Error() string {
+	if e.Line == "" {
+		return fmt.Sprintf("%s/%s: %v", e.Cluster, e.Shard, e.Err)
+	}
+	return fmt.Sprintf("%s/%s: %v (%s)", e.Cluster, e.Shard, e.Err, e.Line)
+}
+
+func (e *StartupError) Unwrap() error {
+	return e.Err
+}
+
+// readyMarkers are log lines printed once a shard has finished loading
+// 世界加载完成时会输出的日志
+var readyMarkers = []string{
+	"Sim paused",
+	"Shard registration",
+	"Server registered via geo DNS",
+	"secondary shard is now ready",
+}
+
+// failureMarkers map log lines to the startup failure they indicate
+// 日志关键字与启动失败类型的对应关系
+var failureMarkers = []struct {
+	text string
+	err  error
+}{
+	{"E_INVALID_TOKEN", ErrInvalidToken},
+	{"E_EXPIRED_TOKEN", ErrInvalidToken},
+	{"No auth token could be found", ErrInvalidToken},
+	{"SOCKET_PORT_ALREADY_IN_USE", ErrPortInUse},
+	{"Address already in use", ErrPortInUse},
+}
+
+// classifyStartupLine reports whether a line means the shard is ready, or
+// the failure it reveals
+// 判断日志行表示就绪还是某种启动失败
+func classifyStartupLine(line string) (ready bool, err error) {
+	for _, marker := range failureMarkers {
+		if strings.Contains(line, marker.text) {
+			return false, marker.err
+		}
+	}
+	for _, marker := range readyMarkers {
+		if strings.Contains(line, marker) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// awaitReady watches a freshly started shard until its log shows it is
+// ready, it reports a known failure, it exits or the timeout passes
+// 等待新启动的世界就绪，或识别出失败、进程退出、超时
+func awaitReady(shard *Shard, timeout time.Duration) error {
+	lines, unsubscribe := shard.Subscribe()
+	defer unsubscribe()
+
+	fail := func(err error, line string) error {
+		return &StartupError{Cluster: shard.Cluster, Shard: shard.Name, Err: err, Line: line}
+	}
+
+	// Lines printed before we subscribed are still in the history
+	for _, line := range shard.Output() {
+		if ready, err := classifyStartupLine(line); err != nil {
+			return fail(err, line)
+		} else if ready {
+			return nil
+		}
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				// The process exited, look for the reason in what it printed
+				output := shard.Output()
+				for i := len(output) - 1; i >= 0; i-- {
+					if _, err := classifyStartupLine(output[i]); err != nil {
+						return fail(err, output[i])
+					}
+				}
+				last := ""
+				if len(output) > 0 {
+					last = output[len(output)-1]
+				}
+				return fail(ErrShardExited, last)
+			}
+			if ready, err := classifyStartupLine(line); err != nil {
+				return fail(err, line)
+			} else if ready {
+				return nil
+			}
+		case <-deadline.C:
+			return fail(ErrStartTimeout, "")
+		}
+	}
+}
+
+// abortShard takes down a shard that failed to start without triggering
+// the watchdog
+// 结束启动失败的世界，不触发自动重启
+func abortShard(shard *Shard, cause error) {
+	shard.MarkFailed(cause)
+	shard.RequestStop()
+	shard.Signal(syscall.SIGTERM)
+	if !waitExit(shard, 10*time.Second) {
+		shard.Signal(os.Kill)
+		waitExit(shard, killWait)
+	}
+}
@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShardState is the lifecycle state of a shard
+// 世界的生命周期状态
+type ShardState string
+
+const (
+	StateStopped  ShardState = "stopped"
+	StateStarting ShardState = "starting"
+	StateRunning  ShardState = "running"
+	StateStopping ShardState = "stopping"
+	StateCrashed  ShardState = "crashed"
+	StateUpdating ShardState = "updating"
+)
+
+// ShardStatus is a snapshot of one shard for the menu and the API
+// 世界状态快照
+type ShardStatus struct {
+	Cluster        string     `json:"cluster"`
+	Shard          string     `json:"shard"`
+	State          ShardState `json:"state"`
+	PID            int        `json:"pid,omitempty"`
+	StartedAt      time.Time  `json:"started_at,omitempty"`
+	UptimeSeconds  int64      `json:"uptime_seconds"`
+	LastExitReason string     `json:"last_exit_reason,omitempty"`
+	LastChange     time.Time  `json:"last_change"`
+}
+
+type trackedShard struct {
+	state      ShardState
+	lastChange time.Time
+	lastExit   string
+}
+
+// stateTracker remembers the state of every shard the manager has touched,
+// including shards whose process is gone
+// 记录所有世界的状态，包括进程已经不在的世界
+type stateTracker struct {
+	mu     sync.Mutex
+	shards map[string]*trackedShard
+}
+
+func newStateTracker() *stateTracker {
+	return &stateTracker{shards: make(map[string]*trackedShard)}
+}
+
+func (t *stateTracker) set(cluster, shard string, state ShardState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entry(cluster, shard).setState(state)
+}
+
+// ready moves a starting shard to running; a shard that already exited
+// keeps its crashed or stopped state
+func (t *stateTracker) ready(cluster, shard string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if entry := t.entry(cluster, shard); entry.state == StateStarting {
+		entry.setState(StateRunning)
+	}
+}
+
+func (t *stateTracker) exited(cluster, shard string, state ShardState, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry := t.entry(cluster, shard)
+	entry.setState(state)
+	entry.lastExit = reason
+}
+
+func (t *stateTracker) get(cluster, shard string) trackedShard {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if entry, ok := t.shards[shardKey(cluster, shard)]; ok {
+		return *entry
+	}
+	return trackedShard{state: StateStopped}
+}
+
+// clusters returns the clusters with at least one shard not stopped
+func (t *stateTracker) clusters() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen := make(map[string]bool)
+	var clusters []string
+	for key, entry := range t.shards {
+		cluster := key[:strings.LastIndex(key, "/")]
+		if entry.state != StateStopped && !seen[cluster] {
+			seen[cluster] = true
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.Strings(clusters)
+	return clusters
+}
+
+func (t *stateTracker) entry(cluster, shard string) *trackedShard {
+	key := shardKey(cluster, shard)
+	entry, ok := t.shards[key]
+	if !ok {
+		entry = &trackedShard{state: StateStopped}
+		t.shards[key] = entry
+	}
+	return entry
+}
+
+func (e *trackedShard) setState(state ShardState) {
+	if e.state != state {
+		e.state = state
+		e.lastChange = time.Now()
+	}
+}
+
+// track follows a shard process until it exits and records why it ended
+// 跟踪世界进程直到退出，并记录退出原因
+func (m *Manager) track(shard *Shard) {
+	m.states.set(shard.Cluster, shard.Name, StateStarting)
+	// Subscribe right away so no join line is missed
+	lines, unsubscribe := shard.Subscribe()
+	go m.followPlayers(shard, lines, unsubscribe)
+	go m.monitorShard(shard)
+	updates, unsubscribeUpdates := shard.Subscribe()
+	go m.watchOutOfDate(shard, updates, unsubscribeUpdates)
+	go func() {
+		<-shard.Done()
+
+		reason := fmt.Sprintf("退出码 %d", shard.ExitCode())
+		if err := shard.ExitErr(); err != nil {
+			reason = err.Error()
+		}
+		switch {
+		case shard.Failure() != nil:
+			m.states.exited(shard.Cluster, shard.Name, StateCrashed, "启动失败: "+shard.Failure().Error())
+		case shard.StopRequested():
+			m.states.exited(shard.Cluster, shard.Name, StateStopped, "主动停止 ("+reason+")")
+		default:
+			m.states.exited(shard.Cluster, shard.Name, StateCrashed, "意外退出 ("+reason+")")
+			m.instruments.shardCrashes.Inc(shard.Cluster, shard.Name)
+		}
+	}()
+}
+
+// ShardStatus returns the state of one shard
+// 返回单个世界的状态
+func (m *Manager) ShardStatus(cluster, name string) ShardStatus {
+	entry := m.states.get(cluster, name)
+	status := ShardStatus{
+		Cluster:        cluster,
+		Shard:          name,
+		State:          entry.state,
+		LastExitReason: entry.lastExit,
+		LastChange:     entry.lastChange,
+	}
+	if shard, ok := m.Supervisor.Get(cluster, name); ok && shard.Running() {
+		status.PID = shard.PID()
+		status.StartedAt = shard.StartedAt()
+		status.UptimeSeconds = int64(time.Since(shard.StartedAt()).Seconds())
+	}
+	return status
+}
+
+// ClusterStatus returns the state of every shard of a cluster, both the
+// ones found on disk and any the manager still tracks
+// 返回存档中所有世界的状态
+func (m *Manager) ClusterStatus(cluster string) []ShardStatus {
+	var statuses []ShardStatus
+	for _, name := range m.stopTargets(cluster) {
+		statuses = append(statuses, m.ShardStatus(cluster, name))
+	}
+	return statuses
+}
+
+// ActiveClusters returns the clusters with a shard that is not stopped
+// 返回有世界不处于停止状态的存档
+func (m *Manager) ActiveClusters() []string {
+	return m.states.clusters()
+}
@@ -0,0 +1,220 @@
+package manager
+
+import (
+	"dst-manager/utils"
+	"dst-manager/utils/vdf"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// appStateFlags names the bits of StateFlags in an appmanifest
+// appmanifest 中 StateFlags 各位的含义
+var appStateFlags = []struct {
+	bit  int64
+	name string
+}{
+	{1, "uninstalled"},
+	{2, "update_required"},
+	{4, "fully_installed"},
+	{8, "encrypted"},
+	{16, "locked"},
+	{32, "files_missing"},
+	{64, "app_running"},
+	{128, "files_corrupt"},
+	{256, "update_running"},
+	{512, "update_paused"},
+	{1024, "update_started"},
+	{2048, "uninstalling"},
+	{4096, "backup_running"},
+	{65536, "reconfiguring"},
+	{131072, "validating"},
+	{262144, "adding_files"},
+	{524288, "preallocating"},
+	{1048576, "downloading"},
+	{2097152, "staging"},
+	{4194304, "committing"},
+	{8388608, "update_stopping"},
+}
+
+// stateFullyInstalled is the StateFlags bit of a complete install
+const stateFullyInstalled = 4
+
+// InstallInfo describes the installed server as recorded in its appmanifest
+// 已安装服务端的信息，来自 appmanifest
+type InstallInfo struct {
+	Installed    bool      `json:"installed"`
+	AppID        string    `json:"app_id"`
+	Name         string    `json:"name,omitempty"`
+	BuildID      string    `json:"build_id,omitempty"`
+	Branch       string    `json:"branch,omitempty"`
+	StateFlags   int64     `json:"state_flags"`
+	State        []string  `json:"state"`
+	SizeOnDisk   int64     `json:"size_on_disk"`
+	LastUpdated  time.Time `json:"last_updated,omitempty"`
+	InstallDir   string    `json:"install_dir"`
+	ManifestPath string    `json:"manifest_path"`
+}
+
+// FullyInstalled reports whether Steam considers the install complete
+// Steam 是否认为安装已完成
+func (info InstallInfo) FullyInstalled() bool {
+	return info.StateFlags&stateFullyInstalled != 0
+}
+
+// BranchInfo is one branch of the server app on Steam
+// Steam 上服务端的一个分支
+type BranchInfo struct {
+	Name             string    `json:"name"`
+	BuildID          string    `json:"build_id"`
+	Description      string    `json:"description,omitempty"`
+	PasswordRequired bool      `json:"password_required"`
+	UpdatedAt        time.Time `json:"updated_at,omitempty"`
+}
+
+// AppInfo is what steamcmd +app_info_print reports about the server app
+// steamcmd +app_info_print 给出的服务端信息
+type AppInfo struct {
+	AppID    string       `json:"app_id"`
+	Name     string       `json:"name,omitempty"`
+	Branches []BranchInfo `json:"branches"`
+}
+
+// Branch returns the named branch, if Steam lists it
+// 返回指定分支
+func (info *AppInfo) Branch(name string) (BranchInfo, bool) {
+	for _, branch := range info.Branches {
+		if strings.EqualFold(branch.Name, name) {
+			return branch, true
+		}
+	}
+	return BranchInfo{}, false
+}
+
+// appManifestPath returns where SteamCMD records an install in dir
+func appManifestPath(dir string) string {
+	return filepath.Join(dir, "steamapps", "appmanifest_"+dstAppID+".acf")
+}
+
+// InstallInfo reads the appmanifest of the install that InstallDST
+// updates. A missing manifest is not an error: Installed is false.
+// 读取服务端的 appmanifest，文件不存在时 Installed 为 false
+func (m *Manager) InstallInfo() (InstallInfo, error) {
+	return readInstallInfo(m.Config.DSTInstallDir)
+}
+
+// readInstallInfo reads the appmanifest of the install in dir
+func readInstallInfo(dir string) (InstallInfo, error) {
+	info := InstallInfo{
+		AppID:        dstAppID,
+		State:        []string{},
+		InstallDir:   dir,
+		ManifestPath: appManifestPath(dir),
+	}
+
+	f, err := os.Open(info.ManifestPath)
+	if os.IsNotExist(err) {
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	doc, err := vdf.Parse(f)
+	if err != nil {
+		return info, err
+	}
+	state := doc.Get("AppState")
+	if state == nil {
+		return info, fmt.Errorf("%s 中没有 AppState", info.ManifestPath)
+	}
+
+	info.Installed = true
+	info.Name = state.String("name")
+	info.BuildID = state.String("buildid")
+	info.StateFlags, _ = strconv.ParseInt(state.String("StateFlags"), 10, 64)
+	info.SizeOnDisk, _ = strconv.ParseInt(state.String("SizeOnDisk"), 10, 64)
+	if seconds, err := strconv.ParseInt(state.String("LastUpdated"), 10, 64); err == nil && seconds > 0 {
+		info.LastUpdated = time.Unix(seconds, 0)
+	}
+	for _, flag := range appStateFlags {
+		if info.StateFlags&flag.bit != 0 {
+			info.State = append(info.State, flag.name)
+		}
+	}
+	// MountedConfig is what is on disk, UserConfig what was asked for last
+	info.Branch = state.String("MountedConfig", "BetaKey")
+	if info.Branch == "" {
+		info.Branch = state.String("UserConfig", "BetaKey")
+	}
+	if info.Branch == "" {
+		info.Branch = publicBranch
+	}
+	return info, nil
+}
+
+// FetchAppInfo asks SteamCMD for the current app info of the server
+// 通过 SteamCMD 查询服务端的最新信息
+func (m *Manager) FetchAppInfo() (*AppInfo, error) {
+	steamCmdPath := filepath.Join(m.Config.SteamCMDDir, "steamcmd.sh")
+	// app_info_update refreshes the cached app info, which is otherwise
+	// often stale
+	out, err := utils.RunCommandOutput(steamCmdPath,
+		"+login", "anonymous",
+		"+app_info_update", "1",
+		"+app_info_print", dstAppID,
+		"+quit")
+	if err != nil {
+		return nil, fmt.Errorf("运行 SteamCMD 失败: %v", err)
+	}
+	return parseAppInfo(out)
+}
+
+// parseAppInfo finds the app's section in app_info_print output, which is
+// surrounded by SteamCMD's own messages
+func parseAppInfo(out string) (*AppInfo, error) {
+	start := strings.Index(out, `"`+dstAppID+`"`)
+	if start < 0 {
+		return nil, errors.New("SteamCMD 的输出中没有服务端信息")
+	}
+	node, err := vdf.NewDecoder(strings.NewReader(out[start:])).Next()
+	if err != nil {
+		return nil, fmt.Errorf("无法解析 SteamCMD 的输出: %v", err)
+	}
+
+	info := &AppInfo{
+		AppID:    dstAppID,
+		Name:     node.String("common", "name"),
+		Branches: []BranchInfo{},
+	}
+	branches := node.Get("depots", "branches")
+	if branches == nil {
+		return nil, errors.New("SteamCMD 的输出中没有分支信息")
+	}
+	for _, b := range branches.Children {
+		branch := BranchInfo{
+			Name:             b.Key,
+			BuildID:          b.String("buildid"),
+			Description:      b.String("description"),
+			PasswordRequired: b.String("pwdrequired") == "1",
+		}
+		if seconds, err := strconv.ParseInt(b.String("timeupdated"), 10, 64); err == nil && seconds > 0 {
+			branch.UpdatedAt = time.Unix(seconds, 0)
+		}
+		info.Branches = append(info.Branches, branch)
+	}
+	sort.Slice(info.Branches, func(i, j int) bool {
+		// public first, the rest by name
+		if (info.Branches[i].Name == "public") != (info.Branches[j].Name == "public") {
+			return info.Branches[i].Name == "public"
+		}
+		return info.Branches[i].Name < info.Branches[j].Name
+	})
+	return info, nil
+}
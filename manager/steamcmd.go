@@ -0,0 +1,137 @@
+package manager
+
+import (
+	"bufio"
+	"dst-manager/utils/steamcmd"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// progressStep is how many percent apart progress is logged to the console
+const progressStep = 10
+
+// retryPolicy says how to react to one kind of SteamCMD failure
+type retryPolicy struct {
+	// Retries after the first attempt; 0 gives up right away
+	Retries int
+	// Delay before the first retry, growing with every attempt
+	Delay time.Duration
+	// Message tells the user what went wrong and what to do
+	Message string
+}
+
+// installRetryPolicies are keyed by failure kind; problems only the user
+// can fix are not retried
+var installRetryPolicies = map[steamcmd.FailureKind]retryPolicy{
+	steamcmd.FailureMissingConfig: {2, 10 * time.Second,
+		"SteamCMD 报告 Missing configuration，通常重试就好；一直出现的话请确认服务器架构是 x86/amd64"},
+	steamcmd.FailureDiskFull: {0, 0,
+		"磁盘空间不足，请清理磁盘后再安装 (服务端需要几个 GB 的空间)"},
+	steamcmd.FailureDiskWrite: {1, 5 * time.Second,
+		"写入磁盘失败，请检查安装目录的权限和剩余空间"},
+	steamcmd.FailureTimeout: {3, 30 * time.Second,
+		"连接 Steam 超时了，稍后会自动重试"},
+	steamcmd.FailureNetwork: {3, 30 * time.Second,
+		"连接不上 Steam，请检查网络，稍后会自动重试"},
+	steamcmd.FailureRateLimited: {2, 2 * time.Minute,
+		"Steam 限制了登录频率，需要等一会儿再试"},
+	steamcmd.FailureInvalidBeta: {0, 0,
+		"测试分支密码不对，请在安装选项中重新填写"},
+	steamcmd.FailureNoSubscription: {0, 0,
+		"Steam 拒绝了安装请求 (No subscription)，请确认分支名是否正确"},
+	steamcmd.FailureUpdateState: {3, 10 * time.Second,
+		"更新没有完成，重新运行一次通常可以接着下载"},
+	steamcmd.FailureUnknown: {2, 10 * time.Second,
+		"SteamCMD 安装失败了"},
+}
+
+func installRetryPolicy(kind steamcmd.FailureKind) retryPolicy {
+	if policy, ok := installRetryPolicies[kind]; ok {
+		return policy
+	}
+	return installRetryPolicies[steamcmd.FailureUnknown]
+}
+
+// InstallError is returned when InstallDST gives up
+// 安装服务端最终失败时返回的错误
+type InstallError struct {
+	Failure  steamcmd.Failure
+	Message  string
+	Attempts int
+}
+
+func (e *InstallError) Error() string {
+	return fmt.Sprintf("%s (%s)", e.Message, e.Failure.Line)
+}
+
+// runSteamCMD runs SteamCMD with args, turning its output into progress on
+// the job and the console. It returns the failure SteamCMD reported, or
+// nil when it succeeded.
+func (m *Manager) runSteamCMD(args []string, job *jobHandle) *steamcmd.Failure {
+	return m.runSteamCMDLines(args, job, nil)
+}
+
+// runSteamCMDLines is runSteamCMD with a hook that sees every output line
+// first; lines the hook returns true for are not taken as a failure of
+// the whole run
+func (m *Manager) runSteamCMDLines(args []string, job *jobHandle, handle func(line string) bool) *steamcmd.Failure {
+	cmd := exec.Command(filepath.Join(m.Config.SteamCMDDir, "steamcmd.sh"), args...)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return &steamcmd.Failure{Kind: steamcmd.FailureUnknown, Line: "无法运行 SteamCMD: " + err.Error()}
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+
+	var failure *steamcmd.Failure
+	phase, logged := "", -progressStep
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(steamcmd.ScanLines)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if p, ok := steamcmd.ParseProgress(line); ok {
+			job.progress(p)
+			if p.Phase != phase || int(p.Percent) >= logged+progressStep {
+				phase, logged = p.Phase, int(p.Percent)/progressStep*progressStep
+				m.Log("%s %.1f%% (%d / %d MB)", p.Phase, p.Percent, p.Done>>20, p.Total>>20)
+			}
+			continue
+		}
+		job.logf("%s", line)
+		if handle != nil && handle(line) {
+			continue
+		}
+		// The first specific reason is the useful one; later lines tend to
+		// be "state is 0x... after update job" consequences
+		if f, ok := steamcmd.ParseFailure(line); ok {
+			if failure == nil || (failure.Kind == steamcmd.FailureUnknown && f.Kind != steamcmd.FailureUnknown) {
+				failure = &f
+			}
+		}
+	}
+	// Keep draining so SteamCMD never blocks on a full pipe
+	io.Copy(io.Discard, pr)
+
+	err := <-waitErr
+	if failure != nil {
+		return failure
+	}
+	if err != nil {
+		return &steamcmd.Failure{Kind: steamcmd.FailureUnknown, Line: "SteamCMD 异常退出: " + err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+package manager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+)
+
+// outputHistory is how many recent output lines each shard keeps
+// 每个世界保留的最近输出行数
+const outputHistory = 200
+
+// ShardSpec describes how to launch a shard process
+// 启动世界进程所需的参数
+type ShardSpec struct {
+	Cluster string
+	Name    string
+	Binary  string
+	Dir     string
+	Args    []string
+}
+
+// Shard is a handle to a shard process owned by the supervisor
+// 由守护进程管理的世界进程句柄
+type Shard struct {
+	Cluster string
+	Name    string
+
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	pid       int
+	startedAt time.Time
+	done      chan struct{}
+
+	mu            sync.Mutex
+	exitCode      int
+	exitErr       error
+	exitedAt      time.Time
+	stopRequested bool
+	failure       error
+	output        []string
+	subs          map[chan string]struct{}
+}
+
+// shardKey identifies a shard across clusters
+// 世界在所有存档中的唯一标识
+func shardKey(cluster, shard string) string {
+	return cluster + "/" + shard
+}
+
+// Supervisor spawns shard processes and tracks them until they exit
+// 世界进程守护者：负责启动并跟踪世界进程
+type Supervisor struct {
+	mu     sync.Mutex
+	shards map[string]*Shard
+}
+
+// NewSupervisor creates an empty Supervisor
+// 创建守护者实例
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		shards: make(map[string]*Shard),
+	}
+}
+
+// Start launches a shard process, refusing if the same cluster shard is alive
+// 启动世界进程，同一存档的同名世界正在运行时拒绝启动
+func (s *Supervisor) Start(spec ShardSpec) (*Shard, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := shardKey(spec.Cluster, spec.Name)
+	if old, ok := s.shards[key]; ok && old.Running() {
+		return nil, fmt.Errorf("%s 已经在运行了 (PID %d)", key, old.PID())
+	}
+
+	cmd := exec.Command(spec.Binary, spec.Args...)
+	cmd.Dir = spec.Dir
+	cmd.SysProcAttr = shardProcAttr()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// Own the output pipe instead of using StdoutPipe, so Wait returns as
+	// soon as the shard exits even if something else still holds the pipe
+	output, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+
+	shard := &Shard{
+		Cluster:   spec.Cluster,
+		Name:      spec.Name,
+		cmd:       cmd,
+		stdin:     stdin,
+		pid:       cmd.Process.Pid,
+		startedAt: time.Now(),
+		done:      make(chan struct{}),
+		exitCode:  -1,
+		subs:      make(map[chan string]struct{}),
+	}
+
+	go shard.pump(output)
+	go func() {
+		err := cmd.Wait()
+		shard.exited(err)
+	}()
+
+	s.shards[key] = shard
+	return shard, nil
+}
+
+// Get returns the latest handle for a shard of a cluster
+// 获取指定存档中指定世界的句柄
+func (s *Supervisor) Get(cluster, name string) (*Shard, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shard, ok := s.shards[shardKey(cluster, name)]
+	return shard, ok
+}
+
+// Running returns the handles of all live shards, sorted by cluster and name
+// 返回所有正在运行的世界
+func (s *Supervisor) Running() []*Shard {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var running []*Shard
+	for _, shard := range s.shards {
+		if shard.Running() {
+			running = append(running, shard)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return shardKey(running[i].Cluster, running[i].Name) < shardKey(running[j].Cluster, running[j].Name)
+	})
+	return running
+}
+
+// RunningClusters returns the names of clusters with at least one live shard
+// 返回至少有一个世界在运行的存档名
+func (s *Supervisor) RunningClusters() []string {
+	var clusters []string
+	seen := make(map[string]bool)
+	for _, shard := range s.Running() {
+		if !seen[shard.Cluster] {
+			seen[shard.Cluster] = true
+			clusters = append(clusters, shard.Cluster)
+		}
+	}
+	return clusters
+}
+
+// PID returns the process id of the shard
+// 进程号
+func (sh *Shard) PID() int {
+	return sh.pid
+}
+
+// StartedAt returns when the process was spawned
+// 启动时间
+func (sh *Shard) StartedAt() time.Time {
+	return sh.startedAt
+}
+
+// Done is closed once the process has exited
+// 进程退出后关闭
+func (sh *Shard) Done() <-chan struct{} {
+	return sh.done
+}
+
+// Running reports whether the process is still alive
+// 进程是否仍在运行
+func (sh *Shard) Running() bool {
+	select {
+	case <-sh.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait blocks until the process exits and returns its exit code
+// 等待进程退出并返回退出码
+func (sh *Shard) Wait() (int, error) {
+	<-sh.done
+	return sh.ExitCode(), sh.ExitErr()
+}
+
+// ExitCode returns the exit code, or -1 while running or when killed by a signal
+// 退出码（运行中或被信号杀死时为 -1）
+func (sh *Shard) ExitCode() int {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.exitCode
+}
+
+// ExitErr returns the error reported by Wait, if any
+// 进程退出时的错误
+func (sh *Shard) ExitErr() error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.exitErr
+}
+
+// ExitedAt returns when the process exited
+// 退出时间
+func (sh *Shard) ExitedAt() time.Time {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.exitedAt
+}
+
+// RequestStop marks the shard as stopped on purpose, so its exit is not
+// treated as a crash
+// 标记为主动停止，退出时不算崩溃
+func (sh *Shard) RequestStop() {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.stopRequested = true
+}
+
+// StopRequested reports whether RequestStop was called
+// 是否为主动停止
+func (sh *Shard) StopRequested() bool {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.stopRequested
+}
+
+// MarkFailed records why a shard is being taken down after failing to start
+// 记录世界启动失败的原因
+func (sh *Shard) MarkFailed(err error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.failure = err
+}
+
+// Failure returns the error passed to MarkFailed, if any
+// 启动失败的原因
+func (sh *Shard) Failure() error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.failure
+}
+
+// SendCommand writes a console command to the shard's stdin
+// 向世界控制台发送指令
+func (sh *Shard) SendCommand(command string) error {
+	if !sh.Running() {
+		return fmt.Errorf("%s/%s 没有在运行", sh.Cluster, sh.Name)
+	}
+	_, err := io.WriteString(sh.stdin, command+"\n")
+	return err
+}
+
+// Signal delivers a signal to the shard process
+// 向世界进程发送信号
+func (sh *Shard) Signal(sig os.Signal) error {
+	if !sh.Running() {
+		return nil
+	}
+	err := sh.cmd.Process.Signal(sig)
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return err
+}
+
+// Output returns a copy of the most recent output lines
+// 最近的输出内容
+func (sh *Shard) Output() []string {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return append([]string(nil), sh.output...)
+}
+
+// Subscribe streams every new output line until cancel is called or the
+// process exits. Slow readers drop lines rather than blocking the shard.
+// 订阅新的输出行；读取太慢时会丢弃而不是阻塞进程
+func (sh *Shard) Subscribe() (<-chan string, func()) {
+	ch := make(chan string, 256)
+
+	sh.mu.Lock()
+	if !sh.Running() {
+		sh.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	sh.subs[ch] = struct{}{}
+	sh.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			sh.mu.Lock()
+			defer sh.mu.Unlock()
+			if _, ok := sh.subs[ch]; ok {
+				delete(sh.subs, ch)
+				close(ch)
+			}
+		})
+	}
+	return ch, cancel
+}
+
+func (sh *Shard) pump(r io.ReadCloser) {
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		sh.mu.Lock()
+		sh.output = append(sh.output, line)
+		if len(sh.output) > outputHistory {
+			sh.output = sh.output[len(sh.output)-outputHistory:]
+		}
+		for ch := range sh.subs {
+			select {
+			case ch <- line:
+			default:
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+func (sh *Shard) exited(err error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.exitErr = err
+	sh.exitedAt = time.Now()
+	if state := sh.cmd.ProcessState; state != nil {
+		sh.exitCode = state.ExitCode()
+	}
+	sh.stdin.Close()
+
+	for ch := range sh.subs {
+		delete(sh.subs, ch)
+		close(ch)
+	}
+	close(sh.done)
+}
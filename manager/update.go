@@ -0,0 +1,404 @@
+package manager
+
+import (
+	"dst-manager/utils/clusterUtils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// dstAppID is the Steam app id of the dedicated server
+	dstAppID = "343050"
+	// updateSettingsFile keeps the update checker settings in DataDir
+	updateSettingsFile = "update.json"
+	// updateCooldown stops an out of date log line from triggering another
+	// update right after one finished, before Steam has caught up
+	updateCooldown = 30 * time.Minute
+)
+
+// ErrUpdateInProgress is returned when an update is already running
+// 已经在更新中
+var ErrUpdateInProgress = errors.New("游戏更新正在进行中")
+
+// UpdateSettings controls the automatic update checker
+// 自动更新设置
+type UpdateSettings struct {
+	// Check Steam for a new build every CheckIntervalMinutes
+	Enabled              bool `json:"enabled"`
+	CheckIntervalMinutes int  `json:"check_interval_minutes"`
+	// Update when a shard logs that the server is out of date
+	OnOutOfDateLog bool `json:"on_out_of_date_log"`
+	// Countdown announced before the running clusters are stopped
+	GraceSeconds int `json:"grace_seconds"`
+}
+
+// UpdateStatus is the result of the latest update check
+// 最近一次更新检查的结果
+type UpdateStatus struct {
+	InstalledBuild string    `json:"installed_build"`
+	LatestBuild    string    `json:"latest_build,omitempty"`
+	Available      bool      `json:"available"`
+	CheckedAt      time.Time `json:"checked_at,omitempty"`
+	CheckError     string    `json:"check_error,omitempty"`
+	Updating       bool      `json:"updating"`
+	LastUpdate     time.Time `json:"last_update,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+	// NotInstalled means there is no appmanifest to compare with, either
+	// nothing is installed or it came from an archive without one
+	NotInstalled bool `json:"not_installed"`
+}
+
+// updater remembers the latest check and whether an update is running
+type updater struct {
+	mu      sync.Mutex
+	status  UpdateStatus
+	started bool
+	// installing is set while SteamCMD rewrites the server files
+	installing bool
+	// outOfDateAt is when a shard last triggered an update from its log
+	outOfDateAt time.Time
+}
+
+func newUpdater() *updater {
+	return &updater{}
+}
+
+// DefaultUpdateSettings returns the settings used when there is no file
+// 默认更新设置
+func DefaultUpdateSettings() *UpdateSettings {
+	return &UpdateSettings{
+		Enabled:              true,
+		CheckIntervalMinutes: 30,
+		OnOutOfDateLog:       true,
+		GraceSeconds:         300,
+	}
+}
+
+// LoadUpdateSettings reads the update settings, falling back to defaults
+// 读取更新设置，缺失时使用默认值
+func (m *Manager) LoadUpdateSettings() (*UpdateSettings, error) {
+	settings := DefaultUpdateSettings()
+
+	data, err := os.ReadFile(filepath.Join(m.Config.DataDir, updateSettingsFile))
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, settings); err != nil {
+		return DefaultUpdateSettings(), fmt.Errorf("解析 %s 失败: %v", updateSettingsFile, err)
+	}
+	return settings, nil
+}
+
+// SaveUpdateSettings writes the update settings
+// 保存更新设置
+func (m *Manager) SaveUpdateSettings(settings *UpdateSettings) error {
+	if err := os.MkdirAll(m.Config.DataDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.Config.DataDir, updateSettingsFile), data, 0644)
+}
+
+// InstalledBuildID returns the build id recorded in the server's appmanifest
+// 从 appmanifest 读取已安装的版本号
+func (m *Manager) InstalledBuildID() (string, error) {
+	info, err := m.InstallInfo()
+	if err != nil {
+		return "", err
+	}
+	if !info.Installed {
+		return "", os.ErrNotExist
+	}
+	return info.BuildID, nil
+}
+
+// LatestBuildID asks SteamCMD for the build id of the branch chosen in
+// the install options
+// 通过 SteamCMD 查询所选分支的最新版本号
+func (m *Manager) LatestBuildID() (string, error) {
+	options, _ := m.LoadInstallOptions()
+	info, err := m.FetchAppInfo()
+	if err != nil {
+		return "", err
+	}
+	branch, ok := info.Branch(options.BranchName())
+	if !ok || branch.BuildID == "" {
+		return "", fmt.Errorf("SteamCMD 的输出中没有 %s 分支的版本号", options.BranchName())
+	}
+	return branch.BuildID, nil
+}
+
+// CheckForUpdate compares the installed build with the latest one
+// 比较已安装版本和最新版本
+func (m *Manager) CheckForUpdate() (UpdateStatus, error) {
+	installed, installedErr := m.InstalledBuildID()
+	latest, err := m.LatestBuildID()
+
+	m.updates.mu.Lock()
+	defer m.updates.mu.Unlock()
+	status := &m.updates.status
+	status.InstalledBuild = installed
+	status.CheckedAt = time.Now()
+	status.CheckError = ""
+	if err == nil && installedErr != nil && !os.IsNotExist(installedErr) {
+		err = installedErr
+	}
+	if err != nil {
+		status.CheckError = err.Error()
+		return *status, err
+	}
+	status.LatestBuild = latest
+	// Without a manifest there is nothing to update; installing is left
+	// to the user
+	status.NotInstalled = installed == ""
+	status.Available = installed != "" && installed != latest
+	return *status, nil
+}
+
+// UpdateStatus returns the result of the latest check
+// 返回最近一次检查的结果
+func (m *Manager) UpdateStatus() UpdateStatus {
+	m.updates.mu.Lock()
+	defer m.updates.mu.Unlock()
+	return m.updates.status
+}
+
+// UpdateAndRestart announces the update on every running cluster, stops
+// them, updates the server and starts them again
+// 公告后停止所有运行中的存档，更新游戏，再重新启动它们
+func (m *Manager) UpdateAndRestart(reason string) error {
+	m.updates.mu.Lock()
+	if m.updates.status.Updating {
+		m.updates.mu.Unlock()
+		return ErrUpdateInProgress
+	}
+	m.updates.status.Updating = true
+	m.updates.mu.Unlock()
+
+	err := m.updateAndRestart(reason)
+
+	m.updates.mu.Lock()
+	m.updates.status.Updating = false
+	m.updates.status.LastError = ""
+	if err != nil {
+		m.updates.status.LastError = err.Error()
+	}
+	if !errors.Is(err, ErrCountdownCancelled) {
+		m.updates.status.LastUpdate = time.Now()
+	}
+	m.updates.mu.Unlock()
+	return err
+}
+
+func (m *Manager) updateAndRestart(reason string) error {
+	settings, err := m.LoadUpdateSettings()
+	if err != nil {
+		m.Log("读取更新设置失败了喵，使用默认设置: %v", err)
+	}
+	// Clusters pinned to a kept build keep running through the update
+	clusters := m.runningOnCurrentInstall()
+	if len(clusters) > 0 {
+		m.Log("准备更新游戏 (%s)，需要停止的存档: %s", reason, strings.Join(clusters, ", "))
+	} else {
+		m.Log("准备更新游戏 (%s)，现在没有运行中的存档", reason)
+	}
+
+	// Every unpinned cluster runs from the same install, so all of them
+	// have to be down; cancelling any countdown calls off the whole update
+	grace := time.Duration(settings.GraceSeconds) * time.Second
+	errs := make([]error, len(clusters))
+	var wg sync.WaitGroup
+	for i, cluster := range clusters {
+		wg.Add(1)
+		go func(i int, cluster string) {
+			defer wg.Done()
+			clusterSettings, _ := m.LoadClusterSettings(cluster)
+			errs[i] = m.countdown(cluster, grace, clusterSettings.Countdown.UpdateMessage, clusterSettings.Countdown)
+			if errs[i] != nil {
+				for _, other := range clusters {
+					m.CancelCountdown(other)
+				}
+			}
+		}(i, cluster)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			m.Log("游戏更新已取消喵: %v", err)
+			return err
+		}
+	}
+
+	for _, cluster := range clusters {
+		wg.Add(1)
+		go func(cluster string) {
+			defer wg.Done()
+			m.StopServer(cluster)
+			for _, name := range m.stopTargets(cluster) {
+				m.states.set(cluster, name, StateUpdating)
+			}
+		}(cluster)
+	}
+	wg.Wait()
+
+	m.updates.mu.Lock()
+	m.updates.installing = true
+	m.updates.mu.Unlock()
+	updateErr := m.InstallDST()
+	m.updates.mu.Lock()
+	m.updates.installing = false
+	m.updates.mu.Unlock()
+	if updateErr != nil {
+		m.Log("游戏更新失败了喵，先用原来的版本重新启动存档: %v", updateErr)
+	}
+
+	var failed []string
+	for _, cluster := range clusters {
+		if err := m.StartServer(cluster); err != nil {
+			failed = append(failed, cluster)
+		}
+	}
+	// Clusters that failed to start are left stopped, not updating
+	for _, cluster := range failed {
+		for _, name := range m.stopTargets(cluster) {
+			if m.states.get(cluster, name).state == StateUpdating {
+				m.states.set(cluster, name, StateStopped)
+			}
+		}
+	}
+
+	if updateErr != nil {
+		return updateErr
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("更新完成，但这些存档没能重新启动: %s", strings.Join(failed, ", "))
+	}
+	if len(clusters) > 0 {
+		m.Log("游戏更新完成，存档都已重新启动喵~")
+	}
+	return nil
+}
+
+// updating reports whether an update is running
+func (m *Manager) updating() bool {
+	m.updates.mu.Lock()
+	defer m.updates.mu.Unlock()
+	return m.updates.status.Updating
+}
+
+// installing reports whether the server files are being updated
+func (m *Manager) installing() bool {
+	m.updates.mu.Lock()
+	installing := m.updates.installing
+	m.updates.mu.Unlock()
+	return installing || m.jobs.running(jobInstallDST)
+}
+
+// StartUpdateChecker checks Steam for a new build in the background and
+// updates when one is found
+// 在后台定期检查新版本，发现后自动更新
+func (m *Manager) StartUpdateChecker() {
+	m.updates.mu.Lock()
+	if m.updates.started {
+		m.updates.mu.Unlock()
+		return
+	}
+	m.updates.started = true
+	m.updates.mu.Unlock()
+
+	go func() {
+		for {
+			settings, err := m.LoadUpdateSettings()
+			if err != nil {
+				m.Log("读取更新设置失败了喵，使用默认设置: %v", err)
+			}
+			interval := time.Duration(settings.CheckIntervalMinutes) * time.Minute
+			if interval <= 0 {
+				interval = 30 * time.Minute
+			}
+			time.Sleep(interval)
+
+			if !settings.Enabled || m.updating() {
+				continue
+			}
+			status, err := m.CheckForUpdate()
+			if err != nil {
+				m.Log("检查游戏更新失败了喵: %v", err)
+				continue
+			}
+			if status.Available {
+				m.Log("发现游戏新版本 %s (当前 %s) 喵！", status.LatestBuild, status.InstalledBuild)
+				if err := m.UpdateAndRestart("发现新版本 " + status.LatestBuild); err != nil {
+					m.Log("自动更新失败了喵: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// isOutOfDateLine reports whether a shard output line says the server
+// build is older than the one on Steam. Chat lines are ignored so players
+// cannot trigger an update.
+func isOutOfDateLine(line string) bool {
+	if !strings.Contains(strings.ToLower(line), "out of date") {
+		return false
+	}
+	_, isChat := clusterUtils.ParseChatLine(line)
+	return !isChat
+}
+
+// watchOutOfDate updates the server when a shard reports that its build
+// is out of date
+// 世界日志提示版本过旧时自动更新
+func (m *Manager) watchOutOfDate(shard *Shard, lines <-chan string, unsubscribe func()) {
+	defer unsubscribe()
+	for line := range lines {
+		if !isOutOfDateLine(line) {
+			continue
+		}
+		// A pinned cluster is old on purpose, updating would not move it
+		if !m.FollowsCurrentInstall(shard.Cluster) {
+			continue
+		}
+		settings, _ := m.LoadUpdateSettings()
+		if !settings.OnOutOfDateLog {
+			continue
+		}
+		// Every shard logs the same line; only the first one acts
+		m.updates.mu.Lock()
+		skip := m.updates.status.Updating ||
+			time.Since(m.updates.status.LastUpdate) < updateCooldown ||
+			time.Since(m.updates.outOfDateAt) < updateCooldown
+		if !skip {
+			m.updates.outOfDateAt = time.Now()
+		}
+		m.updates.mu.Unlock()
+		if skip {
+			continue
+		}
+		m.Log("%s/%s 提示游戏版本过旧了喵: %s", shard.Cluster, shard.Name, line)
+		go func() {
+			// Steam may not list the new build yet; the log line is reason
+			// enough, so a failed check does not stop the update
+			if status, err := m.CheckForUpdate(); err == nil && !status.Available {
+				m.Log("Steam 上还没有比 %s 更新的版本，先不更新喵", status.InstalledBuild)
+				return
+			}
+			if err := m.UpdateAndRestart("世界提示版本过旧"); err != nil && !errors.Is(err, ErrUpdateInProgress) {
+				m.Log("自动更新失败了喵: %v", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,226 @@
+package manager
+
+import (
+	"dst-manager/utils"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// crashLogLines is how much of server_log.txt is kept per crash
+	// 每次崩溃记录的日志行数
+	crashLogLines = 30
+	// crashHistory is how many crashes are remembered per shard
+	// 每个世界保留的崩溃记录数
+	crashHistory = 20
+)
+
+// CrashRecord describes one unexpected shard exit
+// 一次意外退出的记录
+type CrashRecord struct {
+	Cluster  string    `json:"cluster"`
+	Shard    string    `json:"shard"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+	LogTail  []string  `json:"log_tail"`
+}
+
+// watchdog remembers crashes and the restarts waiting on their backoff
+// 看门狗：记录崩溃以及等待退避的重启
+type watchdog struct {
+	mu      sync.Mutex
+	crashes map[string][]CrashRecord
+	pending map[string]chan struct{}
+}
+
+func newWatchdog() *watchdog {
+	return &watchdog{
+		crashes: make(map[string][]CrashRecord),
+		pending: make(map[string]chan struct{}),
+	}
+}
+
+// record stores a crash and returns how many crashes happened within window
+func (w *watchdog) record(key string, crash CrashRecord, window time.Duration) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	history := append(w.crashes[key], crash)
+	if len(history) > crashHistory {
+		history = history[len(history)-crashHistory:]
+	}
+	w.crashes[key] = history
+
+	recent := 0
+	for _, c := range history {
+		if crash.Time.Sub(c.Time) <= window {
+			recent++
+		}
+	}
+	return recent
+}
+
+// arm registers a pending restart and returns the channel that cancels it
+func (w *watchdog) arm(key string) <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if old, ok := w.pending[key]; ok {
+		close(old)
+	}
+	ch := make(chan struct{})
+	w.pending[key] = ch
+	return ch
+}
+
+// disarm forgets a pending restart without cancelling it
+func (w *watchdog) disarm(key string, ch <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending[key] == ch {
+		delete(w.pending, key)
+	}
+}
+
+// cancel aborts a pending restart of the shard, if any
+func (w *watchdog) cancel(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ch, ok := w.pending[key]; ok {
+		close(ch)
+		delete(w.pending, key)
+	}
+}
+
+// history returns the crashes of all shards whose key has the prefix
+func (w *watchdog) history(prefix string) []CrashRecord {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var records []CrashRecord
+	for key, crashes := range w.crashes {
+		if strings.HasPrefix(key, prefix) {
+			records = append(records, crashes...)
+		}
+	}
+	return records
+}
+
+// backoff returns the delay before the n-th restart within the window
+// 计算第 n 次重启前的等待时间
+func backoff(policy RestartPolicy, n int) time.Duration {
+	delay := time.Duration(policy.InitialDelaySeconds) * time.Second
+	max := time.Duration(policy.MaxDelaySeconds) * time.Second
+	for i := 1; i < n && delay < max; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// watch waits for a shard to exit and restarts it if it was not stopped on purpose
+// 看着世界进程，非主动停止时按策略自动重启
+func (m *Manager) watch(shard *Shard) {
+	go func() {
+		<-shard.Done()
+		if shard.StopRequested() {
+			return
+		}
+		m.handleCrash(shard)
+	}()
+}
+
+func (m *Manager) handleCrash(shard *Shard) {
+	key := shardKey(shard.Cluster, shard.Name)
+	// Register the pending restart before any slow work, so a StopServer
+	// from here on cancels it. A stop that came in between the exit and
+	// arm has marked the shard already.
+	cancel := m.watchdog.arm(key)
+	defer m.watchdog.disarm(key, cancel)
+	if shard.StopRequested() {
+		return
+	}
+
+	crash := CrashRecord{
+		Cluster:  shard.Cluster,
+		Shard:    shard.Name,
+		ExitCode: shard.ExitCode(),
+		Time:     shard.ExitedAt(),
+	}
+	if err := shard.ExitErr(); err != nil {
+		crash.Error = err.Error()
+	}
+	crash.LogTail, _ = utils.TailFile(m.serverLogPath(shard.Cluster, shard.Name), crashLogLines)
+
+	m.Log("呜哇！%s 意外退出了喵 (退出码 %d)", key, crash.ExitCode)
+	for _, line := range crash.LogTail {
+		m.Log("  | %s", line)
+	}
+
+	// A restart that fails to start counts as another crash
+	for {
+		settings, err := m.LoadClusterSettings(shard.Cluster)
+		if err != nil {
+			m.Log("读取 %s 的设置失败了喵，使用默认重启策略: %v", shard.Cluster, err)
+		}
+		policy := settings.Restart
+
+		recent := m.watchdog.record(key, crash, time.Duration(policy.WindowSeconds)*time.Second)
+		if !policy.Enabled {
+			m.Log("%s 没有开启自动重启喵，等主人来处理~", shard.Cluster)
+			return
+		}
+		if recent > policy.MaxRestarts {
+			m.Log("%s 在 %d 秒内崩溃了 %d 次，小花酱不再自动重启了喵，请主人检查日志！",
+				key, policy.WindowSeconds, recent)
+			return
+		}
+
+		delay := backoff(policy, recent)
+		m.Log("%s 将在 %v 后自动重启喵 (第 %d 次)", key, delay, recent)
+		select {
+		case <-time.After(delay):
+		case <-cancel:
+			m.Log("%s 的自动重启已取消喵~", key)
+			return
+		}
+
+		restarted, err := m.startShard(shard.Cluster, shard.Name)
+		if err != nil {
+			m.Log("%s 自动重启失败了喵: %v", key, err)
+			crash = CrashRecord{
+				Cluster:  shard.Cluster,
+				Shard:    shard.Name,
+				ExitCode: -1,
+				Error:    "重启失败: " + err.Error(),
+				Time:     time.Now(),
+			}
+			m.states.exited(shard.Cluster, shard.Name, StateCrashed, crash.Error)
+			continue
+		}
+		m.instruments.shardRestarts.Inc(shard.Cluster, shard.Name)
+		m.watch(restarted)
+
+		settings, _ = m.LoadClusterSettings(shard.Cluster)
+		if awaitReady(restarted, time.Duration(settings.Startup.ReadyTimeoutSeconds)*time.Second) == nil {
+			m.states.ready(restarted.Cluster, restarted.Name)
+			m.Log("%s 已重新就绪喵~", key)
+		}
+		return
+	}
+}
+
+// CrashHistory returns the recorded crashes of a cluster's shards
+// 返回存档的崩溃记录
+func (m *Manager) CrashHistory(cluster string) []CrashRecord {
+	return m.watchdog.history(cluster + "/")
+}
+
+// serverLogPath returns the path of a shard's server_log.txt
+// 世界日志文件路径
+func (m *Manager) serverLogPath(cluster, shard string) string {
+	return filepath.Join(m.Config.ClusterDir, cluster, shard, "server_log.txt")
+}
@@ -0,0 +1,14 @@
+package server
+
+import (
+	"dst-manager/manager"
+
+	"github.com/gin-gonic/gin"
+)
+
+func run_doctor(c *gin.Context) {
+	c.JSON(200, Response{
+		Data:   manager.NewManager().Doctor(),
+		Status: 200,
+	})
+}
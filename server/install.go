@@ -0,0 +1,263 @@
+package server
+
+import (
+	"dst-manager/manager"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func install_info(c *gin.Context) {
+	mgr := manager.NewManager()
+	info, err := mgr.InstallInfo()
+	if err != nil {
+		c.JSON(500, Response{
+			Error:   "install_info_error",
+			Status:  500,
+			Message: "读取安装信息失败: " + err.Error(),
+		})
+		return
+	}
+
+	data := gin.H{"installed": info}
+	if record, err := mgr.LastInstalledBuild(); err == nil {
+		data["last_install"] = record
+	}
+	message := ""
+	// Asking Steam takes a few seconds, so only on request
+	if c.Query("latest") == "true" {
+		if appInfo, err := mgr.FetchAppInfo(); err != nil {
+			message = "查询 Steam 上的版本失败: " + err.Error()
+		} else {
+			data["latest"] = appInfo
+		}
+	}
+	c.JSON(200, Response{
+		Data:    data,
+		Status:  200,
+		Message: message,
+	})
+}
+
+func get_install_options(c *gin.Context) {
+	options, err := manager.NewManager().LoadInstallOptions()
+	if err != nil {
+		c.JSON(500, Response{
+			Error:   "install_options_error",
+			Status:  500,
+			Message: "读取安装选项失败: " + err.Error(),
+		})
+		return
+	}
+	// Never hand the beta password back out
+	c.JSON(200, Response{
+		Data: gin.H{
+			"branch":           options.BranchName(),
+			"has_password":     options.BetaPassword != "",
+			"validate":         options.Validate,
+			"keep_versions":    options.KeepVersions,
+			"steamcmd_url":     options.SteamCMDURL,
+			"steamcmd_sha256":  options.SteamCMDSHA256,
+			"http_proxy":       redactURL(options.HTTPProxy),
+			"steamcmd_archive": options.SteamCMDArchive,
+			"dst_archive":      options.DSTArchive,
+		},
+		Status: 200,
+	})
+}
+
+// redactURL hides the password of a proxy URL
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+func update_install_options(c *gin.Context) {
+	mgr := manager.NewManager()
+	options, _ := mgr.LoadInstallOptions()
+
+	// Fields left out of the request keep their saved value
+	var req struct {
+		Branch          *string `json:"branch"`
+		BetaPassword    *string `json:"beta_password"`
+		Validate        *bool   `json:"validate"`
+		KeepVersions    *int    `json:"keep_versions"`
+		SteamCMDURL     *string `json:"steamcmd_url"`
+		SteamCMDSHA256  *string `json:"steamcmd_sha256"`
+		HTTPProxy       *string `json:"http_proxy"`
+		SteamCMDArchive *string `json:"steamcmd_archive"`
+		DSTArchive      *string `json:"dst_archive"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if req.Branch != nil {
+		options.Branch = *req.Branch
+	}
+	if req.BetaPassword != nil {
+		options.BetaPassword = *req.BetaPassword
+	}
+	if req.Validate != nil {
+		options.Validate = *req.Validate
+	}
+	if req.KeepVersions != nil {
+		options.KeepVersions = *req.KeepVersions
+	}
+	if req.SteamCMDURL != nil {
+		options.SteamCMDURL = *req.SteamCMDURL
+	}
+	if req.SteamCMDSHA256 != nil {
+		options.SteamCMDSHA256 = *req.SteamCMDSHA256
+	}
+	if req.HTTPProxy != nil {
+		options.HTTPProxy = *req.HTTPProxy
+	}
+	if req.SteamCMDArchive != nil {
+		options.SteamCMDArchive = *req.SteamCMDArchive
+	}
+	if req.DSTArchive != nil {
+		options.DSTArchive = *req.DSTArchive
+	}
+
+	if err := mgr.SaveInstallOptions(options); err != nil {
+		c.JSON(400, Response{
+			Error:   "install_options_error",
+			Status:  400,
+			Message: "保存安装选项失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Status:  200,
+		Message: "安装选项已保存，下次安装或更新时生效",
+	})
+}
+
+func list_installs(c *gin.Context) {
+	installs, err := manager.NewManager().Installs()
+	if err != nil {
+		c.JSON(500, Response{
+			Error:   "installs_error",
+			Status:  500,
+			Message: "读取已安装版本失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:   installs,
+		Status: 200,
+	})
+}
+
+func pin_install(c *gin.Context) {
+	var req struct {
+		// Empty or "current" follows the current install again
+		Install string `json:"install"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	cluster := c.Param("name")
+	if err := manager.NewManager().PinInstall(cluster, req.Install); err != nil {
+		c.JSON(400, Response{
+			Error:   "pin_install_error",
+			Status:  400,
+			Message: "固定版本失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Status:  200,
+		Message: "已保存，存档下次启动时生效",
+	})
+}
+
+func rollback_cluster(c *gin.Context) {
+	var req struct {
+		GraceSeconds int `json:"grace_seconds"`
+	}
+	c.BindJSON(&req)
+
+	mgr := manager.NewManager()
+	cluster := c.Param("name")
+	target, err := mgr.RollbackTarget(cluster)
+	if err != nil {
+		c.JSON(400, Response{
+			Error:   "rollback_error",
+			Status:  400,
+			Message: "回退失败: " + err.Error(),
+		})
+		return
+	}
+
+	if req.GraceSeconds > 0 && mgr.IsRunning(cluster) {
+		go mgr.RollbackCluster(cluster, time.Duration(req.GraceSeconds)*time.Second)
+		c.JSON(202, Response{
+			Data:    target,
+			Status:  202,
+			Message: "倒计时已开始，结束后使用版本 " + target.BuildID + " 重启服务器",
+		})
+		return
+	}
+
+	target, err = mgr.RollbackCluster(cluster, 0)
+	if err != nil {
+		c.JSON(500, Response{
+			Error:   "rollback_error",
+			Status:  500,
+			Message: "回退失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:    target,
+		Status:  200,
+		Message: "已回退到版本 " + target.BuildID,
+	})
+}
+
+func start_install(c *gin.Context) {
+	mgr := manager.NewManager()
+	// Running clusters hold the files SteamCMD would replace;
+	// POST /api/update stops and restarts them around the install
+	job, err := mgr.StartInstallDST()
+	if err != nil {
+		code := "install_in_progress"
+		if errors.Is(err, manager.ErrCurrentInstallInUse) {
+			code = "cluster_running"
+		}
+		c.JSON(409, Response{
+			Error:   code,
+			Status:  409,
+			Message: err.Error(),
+		})
+		return
+	}
+	c.JSON(202, Response{
+		Data:    job,
+		Status:  202,
+		Message: "已开始安装，可以通过 /api/jobs/" + job.ID + " 查看进度",
+	})
+}
+
+func check_dependencies(c *gin.Context) {
+	report, err := manager.NewManager().CheckDependencies()
+	if err != nil {
+		c.JSON(500, Response{
+			Data:    report,
+			Error:   "dependencies_error",
+			Status:  500,
+			Message: "检查系统依赖失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:   report,
+		Status: 200,
+	})
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"dst-manager/manager"
+
+	"github.com/gin-gonic/gin"
+)
+
+func list_jobs(c *gin.Context) {
+	c.JSON(200, Response{
+		Data:   manager.NewManager().Jobs(),
+		Status: 200,
+	})
+}
+
+func get_job(c *gin.Context) {
+	job, ok := manager.NewManager().Job(c.Param("id"))
+	if !ok {
+		c.JSON(404, Response{
+			Error:   "job_not_found",
+			Status:  404,
+			Message: "找不到任务: " + c.Param("id"),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:   job,
+		Status: 200,
+	})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"dst-manager/config"
+	"dst-manager/server/service"
+	"dst-manager/utils/clusterUtils"
+	"dst-manager/utils/tail"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// sseHeartbeat keeps idle SSE connections from being closed by proxies
+const sseHeartbeat = 15 * time.Second
+
+func get_server_log(c *gin.Context) {
+	lines, err := service.NewClusterService().GetServerLog(c.Param("name"), c.Param("shard"))
+	if err != nil {
+		c.JSON(400, Response{
+			Error:   "log_error",
+			Status:  400,
+			Message: err.Error(),
+		})
+		return
+	}
+	if n, err := strconv.Atoi(c.Query("lines")); err == nil && n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	c.JSON(200, Response{
+		Data:   lines,
+		Status: 200,
+	})
+}
+
+func get_chat_log(c *gin.Context) {
+	query := service.ChatLogQuery{
+		Shard:  c.Query("shard"),
+		Player: c.Query("player"),
+	}
+	for _, kind := range strings.Split(c.Query("kind"), ",") {
+		if kind = strings.TrimSpace(kind); kind != "" {
+			query.Kinds = append(query.Kinds, clusterUtils.ChatKind(kind))
+		}
+	}
+	var err error
+	if since := c.Query("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			c.JSON(400, Response{Error: "invalid_since", Status: 400, Message: "since 需要 RFC3339 格式的时间"})
+			return
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			c.JSON(400, Response{Error: "invalid_until", Status: 400, Message: "until 需要 RFC3339 格式的时间"})
+			return
+		}
+	}
+	query.Page, _ = strconv.Atoi(c.Query("page"))
+	query.PageSize, _ = strconv.Atoi(c.Query("page_size"))
+
+	page, err := service.NewClusterService().GetServerChatLog(c.Param("name"), query)
+	if err != nil {
+		c.JSON(400, Response{
+			Error:   "chat_log_error",
+			Status:  400,
+			Message: err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:   page,
+		Status: 200,
+	})
+}
+
+// stream_logs follows a shard's server_log.txt over SSE, or over a
+// WebSocket when the request asks for an upgrade. Every line carries its
+// number and the offset right after it; a client resumes with ?offset=
+// (or the SSE Last-Event-ID header) or ?line=, and ?from=start replays
+// the whole file.
+func stream_logs(c *gin.Context) {
+	cluster, shard := c.Param("name"), c.Param("shard")
+	if strings.ContainsAny(cluster+shard, "/\\") || strings.Contains(cluster+shard, "..") {
+		c.JSON(400, Response{
+			Error:   "invalid_name",
+			Status:  400,
+			Message: "存档名或世界名包含非法字符",
+		})
+		return
+	}
+	path := filepath.Join(config.NewConfig().ClusterDir, cluster, shard, "server_log.txt")
+
+	from := tail.Position{FromStart: c.Query("from") == "start"}
+	offset := c.Query("offset")
+	if offset == "" {
+		offset = c.GetHeader("Last-Event-ID")
+	}
+	from.Offset, _ = strconv.ParseInt(offset, 10, 64)
+	from.Line, _ = strconv.ParseInt(c.Query("line"), 10, 64)
+
+	if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		streamLogsWebSocket(c, path, from)
+		return
+	}
+	streamLogsSSE(c, path, from)
+}
+
+func streamLogsSSE(c *gin.Context, path string, from tail.Position) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	lines := tail.Follow(ctx, path, from)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return false
+			}
+			data, _ := json.Marshal(line)
+			fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", line.Next, data)
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+func streamLogsWebSocket(c *gin.Context, path string, from tail.Position) {
+	// websocket.Server without a Handshake accepts clients that send no
+	// Origin header, such as command line tools
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		// Any read error means the client went away
+		go func() {
+			var discard []byte
+			for websocket.Message.Receive(ws, &discard) == nil {
+			}
+			cancel()
+		}()
+
+		for line := range tail.Follow(ctx, path, from) {
+			if err := websocket.JSON.Send(ws, line); err != nil {
+				return
+			}
+		}
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+}
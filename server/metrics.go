@@ -0,0 +1,38 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"dst-manager/manager"
+	"dst-manager/utils/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestMetrics times every request by route, so /clusters/:name/status
+// is one series whatever the cluster
+func requestMetrics(duration *metrics.Histogram) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		if err := duration.Observe(time.Since(start).Seconds(), c.Request.Method, route, strconv.Itoa(c.Writer.Status())); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+// serve_metrics writes the Prometheus text exposition. It is left outside
+// the JWT group because scrapers cannot renew tokens.
+func serve_metrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(200)
+	if err := manager.NewManager().Metrics.WriteText(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
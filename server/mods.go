@@ -0,0 +1,74 @@
+package server
+
+import (
+	"dst-manager/manager"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+func list_mods(c *gin.Context) {
+	mods, err := manager.NewManager().Mods()
+	if err != nil {
+		c.JSON(500, Response{
+			Error:   "mods_error",
+			Status:  500,
+			Message: "读取模组失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:   mods,
+		Status: 200,
+	})
+}
+
+func add_mods(c *gin.Context) {
+	var req struct {
+		IDs []string `json:"ids"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+	if err := manager.NewManager().AddMods(req.IDs); err != nil {
+		c.JSON(400, Response{
+			Error:   "add_mods_error",
+			Status:  400,
+			Message: "添加模组失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Status:  200,
+		Message: "已加入 dedicated_server_mods_setup.lua，可以通过 /api/mods/update 下载",
+	})
+}
+
+func update_mods(c *gin.Context) {
+	options := manager.ModUpdateOptions{Method: manager.ModMethodSteamCMD}
+	if err := c.BindJSON(&options); err != nil {
+		return
+	}
+	job, err := manager.NewManager().StartUpdateMods(options)
+	if errors.Is(err, manager.ErrModUpdateInProgress) || errors.Is(err, manager.ErrInstallInProgress) {
+		c.JSON(409, Response{
+			Error:   "job_in_progress",
+			Status:  409,
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(400, Response{
+			Error:   "update_mods_error",
+			Status:  400,
+			Message: "更新模组失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(202, Response{
+		Data:    job,
+		Status:  202,
+		Message: "已开始更新模组，可以通过 /api/jobs/" + job.ID + " 查看进度",
+	})
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"strconv"
+
+	"dst-manager/manager"
+
+	"github.com/gin-gonic/gin"
+)
+
+func list_schedules(c *gin.Context) {
+	c.JSON(200, Response{
+		Data:   manager.NewManager().Scheduler.List(),
+		Status: 200,
+	})
+}
+
+func get_schedule(c *gin.Context) {
+	schedule, ok := manager.NewManager().Scheduler.Get(c.Param("id"))
+	if !ok {
+		c.JSON(404, Response{
+			Error:   "not_found",
+			Status:  404,
+			Message: "找不到定时任务",
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:   schedule,
+		Status: 200,
+	})
+}
+
+func create_schedule(c *gin.Context) {
+	var req manager.Schedule
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	schedule, err := manager.NewManager().Scheduler.Create(req)
+	if err != nil {
+		c.JSON(400, Response{
+			Error:   "invalid_schedule",
+			Status:  400,
+			Message: "创建定时任务失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:    schedule,
+		Status:  200,
+		Message: "定时任务已创建",
+	})
+}
+
+func update_schedule(c *gin.Context) {
+	var req manager.Schedule
+	if err := c.BindJSON(&req); err != nil {
+		return
+	}
+
+	schedule, err := manager.NewManager().Scheduler.Update(c.Param("id"), req)
+	if err != nil {
+		c.JSON(400, Response{
+			Error:   "invalid_schedule",
+			Status:  400,
+			Message: "修改定时任务失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:    schedule,
+		Status:  200,
+		Message: "定时任务已修改",
+	})
+}
+
+func delete_schedule(c *gin.Context) {
+	if err := manager.NewManager().Scheduler.Delete(c.Param("id")); err != nil {
+		c.JSON(404, Response{
+			Error:   "not_found",
+			Status:  404,
+			Message: err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Status:  200,
+		Message: "定时任务已删除",
+	})
+}
+
+func schedule_history(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	runs, err := manager.NewManager().Scheduler.History(c.Param("id"), limit)
+	if err != nil {
+		c.JSON(500, Response{
+			Error:   "history_error",
+			Status:  500,
+			Message: "读取执行记录失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:   runs,
+		Status: 200,
+	})
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"errors"
+	"strconv"
 	"strings"
 	"time"
 
@@ -58,8 +60,34 @@ func login(c *gin.Context) {
 }
 
 func start_server(c *gin.Context) {
-	manager.NewManager().Log("Starting server...")
-	if err := manager.NewManager().StartServer(); err != nil {
+	var req struct {
+		Cluster string `json:"cluster"`
+	}
+	c.BindJSON(&req)
+
+	mgr := manager.NewManager()
+	mgr.Log("Starting server %s...", req.Cluster)
+	if err := mgr.StartServer(req.Cluster); err != nil {
+		var branchErr *manager.BranchMismatchError
+		if errors.As(err, &branchErr) {
+			c.JSON(409, Response{
+				Data:    gin.H{"expected_branch": branchErr.Expected, "installed_branch": branchErr.Installed},
+				Error:   "branch_mismatch",
+				Status:  409,
+				Message: "服务器启动失败: " + err.Error(),
+			})
+			return
+		}
+		var startupErr *manager.StartupError
+		if errors.As(err, &startupErr) {
+			c.JSON(500, Response{
+				Data:    gin.H{"shard": startupErr.Shard, "line": startupErr.Line},
+				Error:   "start_server_error",
+				Status:  500,
+				Message: "服务器启动失败: " + err.Error(),
+			})
+			return
+		}
 		c.JSON(500, Response{
 			Error:   "start_server_error",
 			Status:  500,
@@ -73,13 +101,289 @@ func start_server(c *gin.Context) {
 	})
 }
 
+func stop_server(c *gin.Context) {
+	var req struct {
+		Cluster      string `json:"cluster"`
+		GraceSeconds int    `json:"grace_seconds"`
+	}
+	c.BindJSON(&req)
+
+	mgr := manager.NewManager()
+	if !mgr.IsRunning(req.Cluster) {
+		c.JSON(400, Response{
+			Error:   "not_running",
+			Status:  400,
+			Message: "存档没有在运行: " + req.Cluster,
+		})
+		return
+	}
+
+	// With a countdown the stop happens in the background
+	if req.GraceSeconds > 0 {
+		go mgr.StopServerAfter(req.Cluster, time.Duration(req.GraceSeconds)*time.Second)
+		c.JSON(202, Response{
+			Status:  202,
+			Message: "倒计时已开始，结束后停止服务器",
+		})
+		return
+	}
+
+	results := mgr.StopServer(req.Cluster)
+	c.JSON(200, Response{
+		Data:    results,
+		Status:  200,
+		Message: "服务器已停止",
+	})
+}
+
+func restart_server(c *gin.Context) {
+	var req struct {
+		Cluster      string `json:"cluster"`
+		GraceSeconds int    `json:"grace_seconds"`
+	}
+	c.BindJSON(&req)
+
+	mgr := manager.NewManager()
+	if !mgr.IsRunning(req.Cluster) {
+		c.JSON(400, Response{
+			Error:   "not_running",
+			Status:  400,
+			Message: "存档没有在运行: " + req.Cluster,
+		})
+		return
+	}
+
+	if req.GraceSeconds > 0 {
+		go mgr.RestartServer(req.Cluster, time.Duration(req.GraceSeconds)*time.Second)
+		c.JSON(202, Response{
+			Status:  202,
+			Message: "倒计时已开始，结束后重启服务器",
+		})
+		return
+	}
+
+	if err := mgr.RestartServer(req.Cluster, 0); err != nil {
+		c.JSON(500, Response{
+			Error:   "restart_server_error",
+			Status:  500,
+			Message: "服务器重启失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Status:  200,
+		Message: "服务器已重启",
+	})
+}
+
+func cancel_countdown(c *gin.Context) {
+	if !manager.NewManager().CancelCountdown(c.Param("name")) {
+		c.JSON(404, Response{
+			Error:   "no_countdown",
+			Status:  404,
+			Message: "存档没有在倒计时",
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Status:  200,
+		Message: "倒计时已取消",
+	})
+}
+
+func list_clusters(c *gin.Context) {
+	mgr := manager.NewManager()
+	clusters := []gin.H{}
+	for _, name := range mgr.ListClusters() {
+		clusters = append(clusters, gin.H{
+			"name":    name,
+			"running": mgr.IsRunning(name),
+		})
+	}
+	c.JSON(200, Response{
+		Data:   clusters,
+		Status: 200,
+	})
+}
+
+func running_clusters(c *gin.Context) {
+	running := manager.NewManager().RunningClusters()
+	if running == nil {
+		running = []string{}
+	}
+	c.JSON(200, Response{
+		Data:   running,
+		Status: 200,
+	})
+}
+
+func list_shards(c *gin.Context) {
+	mgr := manager.NewManager()
+	cluster := c.Param("name")
+	shards, err := mgr.ListShards(cluster)
+	if err != nil {
+		c.JSON(400, Response{
+			Error:   "list_shards_error",
+			Status:  400,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	running := map[string]bool{}
+	for _, name := range mgr.RunningShards(cluster) {
+		running[name] = true
+	}
+	list := []gin.H{}
+	for _, shard := range shards {
+		list = append(list, gin.H{
+			"name":      shard.Name,
+			"is_master": shard.IsMaster,
+			"running":   running[shard.Name],
+		})
+	}
+	c.JSON(200, Response{
+		Data:   list,
+		Status: 200,
+	})
+}
+
+func cluster_status(c *gin.Context) {
+	statuses := manager.NewManager().ClusterStatus(c.Param("name"))
+	if statuses == nil {
+		statuses = []manager.ShardStatus{}
+	}
+	c.JSON(200, Response{
+		Data:   statuses,
+		Status: 200,
+	})
+}
+
+func list_players(c *gin.Context) {
+	mgr := manager.NewManager()
+	name := c.Param("name")
+
+	message := ""
+	if c.Query("sync") == "true" {
+		if err := mgr.SyncPlayers(name); err != nil {
+			message = "校对在线列表失败，返回的是日志中记录的玩家: " + err.Error()
+		}
+	}
+	c.JSON(200, Response{
+		Data:    mgr.OnlinePlayers(name),
+		Status:  200,
+		Message: message,
+	})
+}
+
+func cluster_resources(c *gin.Context) {
+	mgr := manager.NewManager()
+	name := c.Param("name")
+
+	shards := mgr.ClusterResources(name)
+	if shards == nil {
+		shards = []manager.ShardResources{}
+	}
+	if c.Query("history") == "false" {
+		for i := range shards {
+			shards[i].History = nil
+		}
+	}
+	c.JSON(200, Response{
+		Data:   gin.H{"shards": shards, "alerts": mgr.ResourceAlerts(name)},
+		Status: 200,
+	})
+}
+
+func console_command(c *gin.Context) {
+	var req struct {
+		Shard   string `json:"shard"`
+		Command string `json:"command"`
+	}
+	c.BindJSON(&req)
+
+	result, err := manager.NewManager().SendConsoleAs(c.GetString("user"), c.Param("name"), req.Shard, req.Command)
+	if err != nil {
+		c.JSON(400, Response{
+			Error:   "console_error",
+			Status:  400,
+			Message: "指令发送失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:    result,
+		Status:  200,
+		Message: "指令已发送",
+	})
+}
+
+func console_audit(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	entries, err := manager.NewManager().ConsoleAudit(c.Param("name"), limit)
+	if err != nil {
+		c.JSON(500, Response{
+			Error:   "audit_error",
+			Status:  500,
+			Message: "读取审计日志失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:   entries,
+		Status: 200,
+	})
+}
+
 func server() *gin.Engine {
 	r := gin.Default()
+	r.Use(requestMetrics(manager.NewManager().HTTPRequestDuration()))
 	r.POST("/login", login)
+	r.GET("/metrics", serve_metrics)
 
 	api := r.Group("/api", auth())
 	{
 		api.POST("/start_server", start_server)
+		api.POST("/stop_server", stop_server)
+		api.POST("/restart_server", restart_server)
+		api.GET("/clusters", list_clusters)
+		api.GET("/clusters/running", running_clusters)
+		api.GET("/clusters/:name/shards", list_shards)
+		api.GET("/clusters/:name/status", cluster_status)
+		api.POST("/clusters/:name/countdown/cancel", cancel_countdown)
+		api.GET("/clusters/:name/shards/:shard/logs", get_server_log)
+		api.GET("/clusters/:name/shards/:shard/logs/stream", stream_logs)
+		api.GET("/clusters/:name/chat", get_chat_log)
+		api.GET("/clusters/:name/players", list_players)
+		api.GET("/clusters/:name/resources", cluster_resources)
+		api.POST("/clusters/:name/console", console_command)
+		api.GET("/clusters/:name/console/audit", console_audit)
+		api.PUT("/clusters/:name/install", pin_install)
+		api.POST("/clusters/:name/rollback", rollback_cluster)
+
+		api.GET("/install/info", install_info)
+		api.GET("/install/options", get_install_options)
+		api.PUT("/install/options", update_install_options)
+		api.GET("/installs", list_installs)
+		api.POST("/install", start_install)
+		api.GET("/install/dependencies", check_dependencies)
+		api.GET("/doctor", run_doctor)
+		api.GET("/mods", list_mods)
+		api.POST("/mods", add_mods)
+		api.POST("/mods/update", update_mods)
+		api.GET("/jobs", list_jobs)
+		api.GET("/jobs/:id", get_job)
+		api.GET("/update", update_status)
+		api.POST("/update", start_update)
+		api.POST("/update/check", check_update)
+
+		api.GET("/schedules", list_schedules)
+		api.POST("/schedules", create_schedule)
+		api.GET("/schedules/history", schedule_history)
+		api.GET("/schedules/:id", get_schedule)
+		api.PUT("/schedules/:id", update_schedule)
+		api.DELETE("/schedules/:id", delete_schedule)
+		api.GET("/schedules/:id/history", schedule_history)
 	}
 	return r
 }
@@ -98,6 +402,10 @@ func tokenFor(user User) (string, error) {
 func auth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tk := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		// EventSource and WebSocket clients cannot set headers
+		if tk == "" {
+			tk = c.Query("token")
+		}
 		token, err := jwt.Parse(tk, func(t *jwt.Token) (any, error) {
 			return jwtSecret, nil
 		})
@@ -105,6 +413,11 @@ func auth() gin.HandlerFunc {
 			c.AbortWithStatus(401)
 			return
 		}
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if user, ok := claims["user"].(string); ok {
+				c.Set("user", user)
+			}
+		}
 		c.Next()
 	}
 }
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -77,7 +78,7 @@ type WorldPreset struct {
 
 type ClusterService interface {
 	ListClusters() ([]string, error)
-	CreateCluster(clusterName string, clusterToken string) error
+	CreateCluster(clusterName string, clusterToken string, withCaves bool) error
 	DeleteCluster(clusterName string) error
 	RenameCluster(clusterName string, newName string) error
 	GetAdminList(clusterName string) ([]string, error)
@@ -93,7 +94,31 @@ type ClusterService interface {
 	GetLevelOverride(clusterName string, levelName string) (*WorldPreset, error)
 	SetLevelOverride(clusterName string, levelName string, override *WorldPreset) error
 	GetServerLog(clusterName string, levelName string) ([]string, error)
-	GetServerChatLog(clusterName string, levelName string) ([]string, error)
+	GetServerChatLog(clusterName string, query ChatLogQuery) (*ChatLogPage, error)
+}
+
+// ChatLogQuery filters and paginates chat log entries
+// 聊天日志查询条件
+type ChatLogQuery struct {
+	// Shard limits the result to one shard, all shards when empty
+	Shard string
+	// Player matches a player name or KU ID
+	Player string
+	Kinds  []clusterUtils.ChatKind
+	Since  time.Time
+	Until  time.Time
+	// Page is 1-based
+	Page     int
+	PageSize int
+}
+
+// ChatLogPage is one page of chat log entries, oldest first
+// 一页聊天日志
+type ChatLogPage struct {
+	Entries  []clusterUtils.ChatEntry `json:"entries"`
+	Total    int                      `json:"total"`
+	Page     int                      `json:"page"`
+	PageSize int                      `json:"page_size"`
 }
 
 type clusterService struct {
@@ -124,7 +149,7 @@ func (c *clusterService) ListClusters() ([]string, error) {
 	return clusters, nil
 }
 
-func (c *clusterService) CreateCluster(clusterName string, clusterToken string) error {
+func (c *clusterService) CreateCluster(clusterName string, clusterToken string, withCaves bool) error {
 	if clusterName == "" {
 		return errors.New("存档名不得为空")
 	}
@@ -147,7 +172,9 @@ func (c *clusterService) CreateCluster(clusterName string, clusterToken string)
 	dirs := []string{
 		clusterPath,
 		filepath.Join(clusterPath, "Master"),
-		filepath.Join(clusterPath, "Caves"),
+	}
+	if withCaves {
+		dirs = append(dirs, filepath.Join(clusterPath, "Caves"))
 	}
 
 	for _, dir := range dirs {
@@ -162,7 +189,7 @@ func (c *clusterService) CreateCluster(clusterName string, clusterToken string)
 	}
 
 	// Write default configs
-	if err := clusterUtils.WriteDefaultConfig(clusterPath, clusterName, ""); err != nil {
+	if err := clusterUtils.WriteDefaultConfig(clusterPath, clusterName, "", withCaves); err != nil {
 		return fmt.Errorf("写入默认配置失败: %v", err)
 	}
 
@@ -276,9 +303,137 @@ func (c *clusterService) SetLevelOverride(clusterName string, levelName string,
 }
 
 func (c *clusterService) GetServerLog(clusterName string, levelName string) ([]string, error) {
-	return nil, nil
+	if clusterName == "" || levelName == "" {
+		return nil, errors.New("存档名和世界名不能为空")
+	}
+	if strings.ContainsAny(clusterName+levelName, "/\\") || strings.Contains(clusterName+levelName, "..") {
+		return nil, errors.New("存档名或世界名包含非法字符")
+	}
+
+	logPath := filepath.Join(c.Config.ClusterDir, clusterName, levelName, "server_log.txt")
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取日志失败: %v", err)
+	}
+
+	text := strings.TrimRight(string(data), "\r\n")
+	if text == "" {
+		return []string{}, nil
+	}
+	return strings.Split(text, "\n"), nil
 }
 
-func (c *clusterService) GetServerChatLog(clusterName string, levelName string) ([]string, error) {
-	return nil, nil
+func (c *clusterService) GetServerChatLog(clusterName string, query ChatLogQuery) (*ChatLogPage, error) {
+	if clusterName == "" {
+		return nil, errors.New("存档名不能为空")
+	}
+	if strings.ContainsAny(clusterName+query.Shard, "/\\") || strings.Contains(clusterName+query.Shard, "..") {
+		return nil, errors.New("存档名或世界名包含非法字符")
+	}
+
+	clusterPath := filepath.Join(c.Config.ClusterDir, clusterName)
+	entries, err := os.ReadDir(clusterPath)
+	if err != nil {
+		return nil, fmt.Errorf("存档目录不存在: %v", err)
+	}
+
+	var all []clusterUtils.ChatEntry
+	for _, entry := range entries {
+		if !entry.IsDir() || (query.Shard != "" && entry.Name() != query.Shard) {
+			continue
+		}
+		shardEntries, err := readShardChatLog(filepath.Join(clusterPath, entry.Name()), entry.Name())
+		if err != nil {
+			continue
+		}
+		all = append(all, shardEntries...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Time.Before(all[j].Time) })
+
+	kinds := map[clusterUtils.ChatKind]bool{}
+	for _, kind := range query.Kinds {
+		kinds[kind] = true
+	}
+	filtered := []clusterUtils.ChatEntry{}
+	for _, e := range all {
+		if len(kinds) > 0 && !kinds[e.Kind] {
+			continue
+		}
+		if query.Player != "" && !strings.EqualFold(e.Player, query.Player) && e.KUID != query.Player {
+			continue
+		}
+		if !query.Since.IsZero() && e.Time.Before(query.Since) {
+			continue
+		}
+		if !query.Until.IsZero() && e.Time.After(query.Until) {
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+
+	if query.PageSize <= 0 {
+		query.PageSize = 50
+	}
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	page := &ChatLogPage{
+		Entries:  []clusterUtils.ChatEntry{},
+		Total:    len(filtered),
+		Page:     query.Page,
+		PageSize: query.PageSize,
+	}
+	start := (query.Page - 1) * query.PageSize
+	if start < len(filtered) {
+		end := start + query.PageSize
+		if end > len(filtered) {
+			end = len(filtered)
+		}
+		page.Entries = filtered[start:end]
+	}
+	return page, nil
+}
+
+// readShardChatLog parses a shard's server_chat_log.txt and fills in what
+// server_log.txt knows about the players. The log only stores the time
+// since the shard started, so wall clock times are estimated from the
+// file's last write, which matches its last entry.
+func readShardChatLog(shardPath, shard string) ([]clusterUtils.ChatEntry, error) {
+	chatPath := filepath.Join(shardPath, "server_chat_log.txt")
+	f, err := os.Open(chatPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := clusterUtils.ParseChatLog(f, shard)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return entries, nil
+	}
+
+	players := map[string]clusterUtils.PlayerIdentity{}
+	if logFile, err := os.Open(filepath.Join(shardPath, "server_log.txt")); err == nil {
+		players, _ = clusterUtils.ParsePlayerIdentities(logFile)
+		logFile.Close()
+	}
+
+	started := info.ModTime().Add(-entries[len(entries)-1].Elapsed)
+	for i := range entries {
+		e := &entries[i]
+		e.Time = started.Add(e.Elapsed)
+		if p, ok := players[e.Player]; ok {
+			if e.KUID == "" {
+				e.KUID = p.KUID
+			}
+			e.Character = p.Character
+		}
+	}
+	return entries, nil
 }
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"dst-manager/manager"
+
+	"github.com/gin-gonic/gin"
+)
+
+func update_status(c *gin.Context) {
+	mgr := manager.NewManager()
+	settings, _ := mgr.LoadUpdateSettings()
+	c.JSON(200, Response{
+		Data:   gin.H{"status": mgr.UpdateStatus(), "settings": settings},
+		Status: 200,
+	})
+}
+
+func check_update(c *gin.Context) {
+	status, err := manager.NewManager().CheckForUpdate()
+	if err != nil {
+		c.JSON(502, Response{
+			Data:    status,
+			Error:   "check_update_error",
+			Status:  502,
+			Message: "检查更新失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(200, Response{
+		Data:   status,
+		Status: 200,
+	})
+}
+
+func start_update(c *gin.Context) {
+	mgr := manager.NewManager()
+	if mgr.UpdateStatus().Updating {
+		c.JSON(409, Response{
+			Error:   "update_in_progress",
+			Status:  409,
+			Message: manager.ErrUpdateInProgress.Error(),
+		})
+		return
+	}
+
+	// Countdowns, SteamCMD and restarts take minutes; follow progress
+	// through GET /api/update
+	go func() {
+		if err := mgr.UpdateAndRestart("接口请求 (" + c.GetString("user") + ")"); err != nil {
+			mgr.Log("更新失败了喵: %v", err)
+		}
+	}()
+	c.JSON(202, Response{
+		Status:  202,
+		Message: "已开始更新，结束后会自动重启运行中的存档",
+	})
+}
@@ -0,0 +1,182 @@
+package clusterUtils
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChatKind is the type of a server_chat_log.txt entry
+// 聊天日志条目类型
+type ChatKind string
+
+const (
+	ChatSay          ChatKind = "Say"
+	ChatWhisper      ChatKind = "Whisper"
+	ChatJoin         ChatKind = "Join"
+	ChatLeave        ChatKind = "Leave"
+	ChatDeath        ChatKind = "Death"
+	ChatResurrect    ChatKind = "Resurrect"
+	ChatAnnouncement ChatKind = "Announcement"
+)
+
+// ChatEntry is one parsed chat log line
+// 解析后的聊天日志条目
+type ChatEntry struct {
+	// Elapsed is the time since the shard started, as written in the log
+	Elapsed time.Duration `json:"elapsed"`
+	// Time is the wall clock time, estimated from the file's modification time
+	Time      time.Time `json:"time"`
+	Shard     string    `json:"shard"`
+	Kind      ChatKind  `json:"kind"`
+	KUID      string    `json:"ku_id,omitempty"`
+	Player    string    `json:"player,omitempty"`
+	Character string    `json:"character,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+var (
+	// [00:01:23]: [Say] (KU_abcdefgh) Name: message
+	chatLineRe = regexp.MustCompile(`^\[(\d+):(\d{2}):(\d{2})\]: \[([^\]]+)\] (.*)$`)
+	chatSayRe  = regexp.MustCompile(`^\((KU_[^)]+)\) ([^:]+): (.*)$`)
+	// Spawn request: wilson from Name
+	spawnRe = regexp.MustCompile(`Spawn request: (\S+) from (.+)$`)
+	// Client authenticated: (KU_abcdefgh) Name
+	authRe = regexp.MustCompile(`Client authenticated: \((KU_[^)]+)\) (.+)$`)
+	// [1] (KU_abcdefgh) Name <wilson>, printed by c_listallplayers()
+	listedRe = regexp.MustCompile(`^(?:\[\d+:\d{2}:\d{2}\]: )?\[\d+\] \((KU_[^)]+)\) (.*) <([^>]*)>$`)
+)
+
+// ParseChatLog parses a server_chat_log.txt. Lines that are not chat
+// entries are skipped.
+// 解析 server_chat_log.txt，跳过无法识别的行
+func ParseChatLog(r io.Reader, shard string) ([]ChatEntry, error) {
+	var entries []ChatEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if entry, ok := ParseChatLine(scanner.Text()); ok {
+			entry.Shard = shard
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// ParseChatLine parses one chat log line. The shard's server_log.txt and
+// console output use the same format for announcements.
+// 解析单行聊天日志，server_log.txt 和控制台输出中的公告也是同样格式
+func ParseChatLine(line string) (ChatEntry, bool) {
+	m := chatLineRe.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if m == nil {
+		return ChatEntry{}, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	sec, _ := strconv.Atoi(m[3])
+	entry := ChatEntry{
+		Elapsed: time.Duration(h)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second,
+	}
+	tag, rest := m[4], m[5]
+
+	switch tag {
+	case "Say", "Whisper":
+		entry.Kind = ChatKind(tag)
+		if sm := chatSayRe.FindStringSubmatch(rest); sm != nil {
+			entry.KUID, entry.Player, entry.Message = sm[1], sm[2], sm[3]
+		} else {
+			entry.Message = rest
+		}
+	case "Join Announcement":
+		entry.Kind, entry.Player = ChatJoin, rest
+	case "Leave Announcement":
+		entry.Kind, entry.Player = ChatLeave, rest
+	case "Death Announcement":
+		entry.Kind, entry.Message = ChatDeath, rest
+		entry.Player = leadingName(rest, " was killed by ", " died")
+	case "Resurrect Announcement":
+		entry.Kind, entry.Message = ChatResurrect, rest
+		entry.Player = leadingName(rest, " was resurrected by ", " resurrected")
+	case "Announcement":
+		entry.Kind, entry.Message = ChatAnnouncement, rest
+	default:
+		return ChatEntry{}, false
+	}
+	return entry, true
+}
+
+// leadingName returns the text before the first separator found
+func leadingName(text string, separators ...string) string {
+	for _, sep := range separators {
+		if i := strings.Index(text, sep); i > 0 {
+			return text[:i]
+		}
+	}
+	return ""
+}
+
+// PlayerIdentity is what server_log.txt tells about a player name
+// 从 server_log.txt 得到的玩家信息
+type PlayerIdentity struct {
+	KUID      string
+	Character string
+}
+
+// ParsePlayerIdentities collects the KU ID and the last chosen character of
+// every player named in a server_log.txt
+// 从 server_log.txt 收集玩家的 KU ID 和最近选择的角色
+func ParsePlayerIdentities(r io.Reader) (map[string]PlayerIdentity, error) {
+	players := make(map[string]PlayerIdentity)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		name, identity, ok := ParsePlayerIdentityLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		p := players[name]
+		if identity.KUID != "" {
+			p.KUID = identity.KUID
+		}
+		if identity.Character != "" {
+			p.Character = identity.Character
+		}
+		players[name] = p
+	}
+	return players, scanner.Err()
+}
+
+// ParsePlayerIdentityLine reads one server_log.txt line. It returns the
+// player name and whichever of the KU ID or the character the line tells.
+// 解析单行 server_log.txt，返回玩家名以及该行给出的 KU ID 或角色
+func ParsePlayerIdentityLine(line string) (string, PlayerIdentity, bool) {
+	line = strings.TrimRight(line, "\r")
+	if m := authRe.FindStringSubmatch(line); m != nil {
+		return m[2], PlayerIdentity{KUID: m[1]}, true
+	}
+	if m := spawnRe.FindStringSubmatch(line); m != nil {
+		return m[2], PlayerIdentity{Character: m[1]}, true
+	}
+	return "", PlayerIdentity{}, false
+}
+
+// ListedPlayer is one line of c_listallplayers() output
+// c_listallplayers() 输出的一行
+type ListedPlayer struct {
+	KUID      string
+	Name      string
+	Character string
+}
+
+// ParsePlayerListLine parses one line printed by c_listallplayers()
+// 解析 c_listallplayers() 输出的一行
+func ParsePlayerListLine(line string) (ListedPlayer, bool) {
+	m := listedRe.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if m == nil {
+		return ListedPlayer{}, false
+	}
+	return ListedPlayer{KUID: m[1], Name: m[2], Character: m[3]}, true
+}
@@ -0,0 +1,146 @@
+package clusterUtils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChatLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want ChatEntry
+		ok   bool
+	}{
+		{
+			"[00:01:23]: [Say] (KU_abcdefgh) Wendy Fan: hello: world",
+			ChatEntry{Elapsed: 83 * time.Second, Kind: ChatSay, KUID: "KU_abcdefgh", Player: "Wendy Fan", Message: "hello: world"},
+			true,
+		},
+		{
+			"[12:00:00]: [Whisper] (KU_x1) Bob: psst\r",
+			ChatEntry{Elapsed: 12 * time.Hour, Kind: ChatWhisper, KUID: "KU_x1", Player: "Bob", Message: "psst"},
+			true,
+		},
+		{
+			"[00:00:05]: [Say] something without a speaker",
+			ChatEntry{Elapsed: 5 * time.Second, Kind: ChatSay, Message: "something without a speaker"},
+			true,
+		},
+		{
+			"[00:10:00]: [Join Announcement] Bob",
+			ChatEntry{Elapsed: 10 * time.Minute, Kind: ChatJoin, Player: "Bob"},
+			true,
+		},
+		{
+			"[00:10:00]: [Leave Announcement] Bob",
+			ChatEntry{Elapsed: 10 * time.Minute, Kind: ChatLeave, Player: "Bob"},
+			true,
+		},
+		{
+			"[01:02:03]: [Death Announcement] Bob was killed by Spider.",
+			ChatEntry{Elapsed: time.Hour + 2*time.Minute + 3*time.Second, Kind: ChatDeath, Player: "Bob", Message: "Bob was killed by Spider."},
+			true,
+		},
+		{
+			"[01:02:03]: [Death Announcement] Bob died mysteriously.",
+			ChatEntry{Elapsed: time.Hour + 2*time.Minute + 3*time.Second, Kind: ChatDeath, Player: "Bob", Message: "Bob died mysteriously."},
+			true,
+		},
+		{
+			"[00:00:30]: [Resurrect Announcement] Bob was resurrected by a Touch Stone.",
+			ChatEntry{Elapsed: 30 * time.Second, Kind: ChatResurrect, Player: "Bob", Message: "Bob was resurrected by a Touch Stone."},
+			true,
+		},
+		{
+			"[00:00:30]: [Announcement] Server restarts in 5 minutes",
+			ChatEntry{Elapsed: 30 * time.Second, Kind: ChatAnnouncement, Message: "Server restarts in 5 minutes"},
+			true,
+		},
+		{"[00:00:30]: [Vote Announcement] kick Bob", ChatEntry{}, false},
+		{"[00:00:30]: Server registered", ChatEntry{}, false},
+		{"[0:1:2]: [Say] (KU_x) a: b", ChatEntry{}, false},
+		{"", ChatEntry{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseChatLine(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseChatLine(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseChatLog(t *testing.T) {
+	log := "[00:00:01]: [Join Announcement] Bob\n" +
+		"garbage\n" +
+		"[00:00:02]: [Say] (KU_b) Bob: hi\n"
+	got, err := ParseChatLog(strings.NewReader(log), "Master")
+	if err != nil {
+		t.Fatalf("ParseChatLog: %v", err)
+	}
+	want := []ChatEntry{
+		{Elapsed: time.Second, Shard: "Master", Kind: ChatJoin, Player: "Bob"},
+		{Elapsed: 2 * time.Second, Shard: "Master", Kind: ChatSay, KUID: "KU_b", Player: "Bob", Message: "hi"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseChatLog = %+v, want %+v", got, want)
+	}
+}
+
+func TestParsePlayerIdentityLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		name     string
+		identity PlayerIdentity
+		ok       bool
+	}{
+		{"[00:00:10]: Client authenticated: (KU_abcdefgh) Wendy Fan", "Wendy Fan", PlayerIdentity{KUID: "KU_abcdefgh"}, true},
+		{"[00:00:12]: Spawn request: wendy from Wendy Fan\r", "Wendy Fan", PlayerIdentity{Character: "wendy"}, true},
+		{"[00:00:12]: New incoming connection", "", PlayerIdentity{}, false},
+	}
+	for _, tt := range tests {
+		name, identity, ok := ParsePlayerIdentityLine(tt.line)
+		if name != tt.name || identity != tt.identity || ok != tt.ok {
+			t.Errorf("ParsePlayerIdentityLine(%q) = %q, %+v, %v; want %q, %+v, %v",
+				tt.line, name, identity, ok, tt.name, tt.identity, tt.ok)
+		}
+	}
+}
+
+func TestParsePlayerIdentities(t *testing.T) {
+	log := "Client authenticated: (KU_a) Bob\n" +
+		"Spawn request: wilson from Bob\n" +
+		"Spawn request: wx78 from Bob\n" +
+		"Spawn request: willow from Ann\n"
+	got, err := ParsePlayerIdentities(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("ParsePlayerIdentities: %v", err)
+	}
+	want := map[string]PlayerIdentity{
+		"Bob": {KUID: "KU_a", Character: "wx78"},
+		"Ann": {Character: "willow"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParsePlayerIdentities = %+v, want %+v", got, want)
+	}
+}
+
+func TestParsePlayerListLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want ListedPlayer
+		ok   bool
+	}{
+		{"[1] (KU_abcdefgh) Wendy Fan <wendy>", ListedPlayer{KUID: "KU_abcdefgh", Name: "Wendy Fan", Character: "wendy"}, true},
+		{"[00:05:00]: [2] (KU_x) Bob <>\r", ListedPlayer{KUID: "KU_x", Name: "Bob"}, true},
+		{"[00:05:00]: [Say] (KU_x) Bob: <wilson>", ListedPlayer{}, false},
+		{"[1] Bob <wilson>", ListedPlayer{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParsePlayerListLine(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParsePlayerListLine(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -9,7 +9,10 @@ import (
 	"strings"
 )
 
-func WriteDefaultConfig(clusterPath string, name string, desc string) error {
+// WriteDefaultConfig writes cluster.ini and a server.ini per shard for a
+// new cluster: Master only, or Master and Caves joined through [SHARD]
+// 写入新存档的默认配置，可选是否带洞穴
+func WriteDefaultConfig(clusterPath string, name string, desc string, withCaves bool) error {
 	if name == "" {
 		name = "New DST Server"
 	}
@@ -18,53 +21,67 @@ func WriteDefaultConfig(clusterPath string, name string, desc string) error {
 	}
 	// cluster.ini
 	clusterIni := `[GAMEPLAY]
-	game_mode = survival
-	max_players = 6
-	pvp = false
-}
-	pause_when_empty = true
-
-	[NETWORK]
-	cluster_name = ` + name + `
-	cluster_description = ` + desc + `
-	cluster_intention = cooperative
-
-	[MISC]
-	console_enabled = true
+game_mode = survival
+max_players = 6
+pvp = false
+pause_when_empty = true
+
+[NETWORK]
+cluster_name = ` + name + `
+cluster_description = ` + desc + `
+cluster_intention = cooperative
+
+[MISC]
+console_enabled = true
 `
+	if withCaves {
+		// Master and Caves talk to each other through the shard section
+		clusterIni += `
+[SHARD]
+shard_enabled = true
+bind_ip = 127.0.0.1
+master_ip = 127.0.0.1
+master_port = 10888
+cluster_key = defaultPass
+`
+	}
 	if err := os.WriteFile(filepath.Join(clusterPath, "cluster.ini"), []byte(clusterIni), 0644); err != nil {
 		return err
 	}
 
 	// Master/server.ini
 	masterIni := `[NETWORK]
-	server_port = 10999
+server_port = 10999
 
-	[SHARD]
-	is_master = true
+[SHARD]
+is_master = true
 
-	[STEAM]
-	authentication_port = 8766
-	master_server_port = 27016
+[STEAM]
+authentication_port = 8766
+master_server_port = 27016
 `
 	if err := os.WriteFile(filepath.Join(clusterPath, "Master", "server.ini"), []byte(masterIni), 0644); err != nil {
 		return err
 	}
 
+	if !withCaves {
+		return nil
+	}
+
 	// Caves/server.ini
 	cavesIni := `[NETWORK]
-	server_port = 10998
+server_port = 10998
 
-	[SHARD]
-	is_master = false
-	name = Caves
+[SHARD]
+is_master = false
+name = Caves
 
-	[STEAM]
-	authentication_port = 8765
-	master_server_port = 27015
+[STEAM]
+authentication_port = 8765
+master_server_port = 27015
 
-	[WORLD]
-	id = 2
+[WORLD]
+id = 2
 `
 	if err := os.WriteFile(filepath.Join(clusterPath, "Caves", "server.ini"), []byte(cavesIni), 0644); err != nil {
 		return err
@@ -147,3 +164,35 @@ func writeAdminList(clusterPath string, adminList []string) error {
 	}
 	return nil
 }
+
+// ReadIni parses a DST style ini file into section -> key -> value.
+// Section names are upper-cased and keys lower-cased.
+// 解析 ini 文件，节名转大写、键名转小写
+func ReadIni(path string) (map[string]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ini := make(map[string]map[string]string)
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToUpper(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if ini[section] == nil {
+			ini[section] = make(map[string]string)
+		}
+		ini[section][strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return ini, nil
+}
@@ -0,0 +1,154 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expression is a parsed five field cron expression:
+// minute hour day-of-month month day-of-week
+// 解析后的五段式 cron 表达式：分 时 日 月 周
+type Expression struct {
+	minute, hour, dom, month, dow uint64
+	// Like classic cron, when both day fields are restricted a day matches
+	// if either of them does
+	domAny, dowAny bool
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression such as "30 4 * * *" or "@daily"
+// 解析 cron 表达式
+func Parse(expr string) (*Expression, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron 表达式需要 5 个字段 (分 时 日 月 周): %q", expr)
+	}
+
+	e := &Expression{}
+	var err error
+	if e.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if e.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if e.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if e.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if e.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// 7 is another name for Sunday
+	if e.dow&(1<<7) != 0 {
+		e.dow |= 1
+	}
+	e.domAny = fields[2] == "*"
+	e.dowAny = fields[4] == "*"
+	return e, nil
+}
+
+// parseField turns "*", "5", "1-5", "*/15", "1,3,5" or "0-30/10" into a bitset
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("无效的步长: %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			a, b, _ := strings.Cut(rangePart, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(a)
+			hi, err2 = strconv.Atoi(b)
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("无效的范围: %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("无效的数值: %q", part)
+			}
+			lo = n
+			if hasStep {
+				hi = max
+			} else {
+				hi = n
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q 超出范围 %d-%d", part, min, max)
+		}
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first matching time strictly after t, in t's location.
+// It returns the zero time if nothing matches within five years.
+// 返回 t 之后第一个匹配的时间（使用 t 的时区），五年内无匹配时返回零值
+func (e *Expression) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if e.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !e.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if e.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if e.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (e *Expression) dayMatches(t time.Time) bool {
+	domMatch := e.dom&(1<<uint(t.Day())) != 0
+	dowMatch := e.dow&(1<<uint(t.Weekday())) != 0
+	if e.domAny || e.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
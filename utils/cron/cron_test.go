@@ -0,0 +1,85 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"30 4 * * *", true},
+		{"@daily", true},
+		{" @weekly ", true},
+		{"*/15 0-6,22,23 1-31/2 * 1-5", true},
+		{"0 0 * * 7", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"@sometimes", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"*/x * * * *", false},
+		{"a * * * *", false},
+		{"1-x * * * *", false},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if (err == nil) != tt.ok {
+			t.Errorf("Parse(%q) error = %v, want ok %v", tt.expr, err, tt.ok)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// A Monday
+	from := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"30 4 * * *", time.Date(2024, 1, 2, 4, 30, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)},
+		{"10 * * * *", time.Date(2024, 1, 1, 11, 10, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 1-5", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either one matching is enough
+		{"0 0 13 * 5", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		if got := e.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%v) = %v, want %v", tt.expr, from, got, tt.want)
+		}
+	}
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	e, err := Parse("0 4 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := e.Next(time.Date(2024, 1, 1, 3, 59, 30, 0, loc))
+	want := time.Date(2024, 1, 1, 4, 0, 0, 0, loc)
+	if !got.Equal(want) || got.Location() != loc {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+}
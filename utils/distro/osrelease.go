@@ -0,0 +1,100 @@
+package distro
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// OSReleasePath is where systemd based and most other distros describe
+// themselves
+const OSReleasePath = "/etc/os-release"
+
+// OSRelease is the part of os-release the manager cares about
+// os-release 中与管理器相关的字段
+type OSRelease struct {
+	ID         string   `json:"id"`
+	IDLike     []string `json:"id_like,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	PrettyName string   `json:"pretty_name,omitempty"`
+	VersionID  string   `json:"version_id,omitempty"`
+}
+
+// Is reports whether the distro is id or is like it
+// 是否为指定发行版或其衍生版
+func (r OSRelease) Is(ids ...string) bool {
+	for _, id := range ids {
+		if r.ID == id {
+			return true
+		}
+		for _, like := range r.IDLike {
+			if like == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Read parses the os-release file at path
+// 读取 os-release 文件
+func Read(path string) (OSRelease, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return OSRelease{}, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads os-release KEY=value lines; values may be quoted
+// 解析 os-release 的 KEY=value 行
+func Parse(r io.Reader) (OSRelease, error) {
+	var release OSRelease
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = unquote(value)
+		switch key {
+		case "ID":
+			release.ID = strings.ToLower(value)
+		case "ID_LIKE":
+			release.IDLike = strings.Fields(strings.ToLower(value))
+		case "NAME":
+			release.Name = value
+		case "PRETTY_NAME":
+			release.PrettyName = value
+		case "VERSION_ID":
+			release.VersionID = value
+		}
+	}
+	return release, scanner.Err()
+}
+
+// unquote strips shell style quotes. Inside double quotes os-release only
+// escapes $, ", \ and `; any other backslash is kept.
+func unquote(value string) string {
+	if len(value) < 2 || (value[0] != '"' && value[0] != '\'') || value[len(value)-1] != value[0] {
+		return value
+	}
+	quote, inner := value[0], value[1:len(value)-1]
+	if quote == '\'' {
+		return inner
+	}
+	var b strings.Builder
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' && i+1 < len(inner) && strings.IndexByte("$\"\\`", inner[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(inner[i])
+	}
+	return b.String()
+}
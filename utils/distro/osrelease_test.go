@@ -0,0 +1,129 @@
+package distro
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want OSRelease
+	}{
+		{
+			"ubuntu",
+			`PRETTY_NAME="Ubuntu 22.04.4 LTS"
+NAME="Ubuntu"
+VERSION_ID="22.04"
+ID=ubuntu
+ID_LIKE=debian
+`,
+			OSRelease{ID: "ubuntu", IDLike: []string{"debian"}, Name: "Ubuntu", PrettyName: "Ubuntu 22.04.4 LTS", VersionID: "22.04"},
+		},
+		{
+			"rocky",
+			`NAME="Rocky Linux"
+ID="rocky"
+ID_LIKE="rhel centos fedora"
+VERSION_ID="9.3"
+`,
+			OSRelease{ID: "rocky", IDLike: []string{"rhel", "centos", "fedora"}, Name: "Rocky Linux", VersionID: "9.3"},
+		},
+		{
+			"comments, blanks and junk",
+			"# a comment\n\n  ID=Arch  \nnot a pair\nBUILD_ID=rolling\n",
+			OSRelease{ID: "arch"},
+		},
+		{
+			"single quotes",
+			"NAME='Alpine Linux'\nID=alpine\n",
+			OSRelease{ID: "alpine", Name: "Alpine Linux"},
+		},
+		{
+			"escaped double quotes",
+			`PRETTY_NAME="Some \"Distro\" \$1"`,
+			OSRelease{PrettyName: `Some "Distro" $1`},
+		},
+		{
+			"bad escape keeps the text",
+			`NAME="a\qb"`,
+			OSRelease{Name: `a\qb`},
+		},
+		{
+			"empty",
+			"",
+			OSRelease{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`plain`, `plain`},
+		{`"double"`, `double`},
+		{`'single'`, `single`},
+		{`"mismatched'`, `"mismatched'`},
+		{`"`, `"`},
+		{`""`, ``},
+		{"\"a\\\\b\\`c\\`\"", "a\\b`c`"},
+		{`'no \$ escapes'`, `no \$ escapes`},
+	}
+	for _, tt := range tests {
+		if got := unquote(tt.in); got != tt.want {
+			t.Errorf("unquote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIs(t *testing.T) {
+	mint := OSRelease{ID: "linuxmint", IDLike: []string{"ubuntu", "debian"}}
+	tests := []struct {
+		ids  []string
+		want bool
+	}{
+		{[]string{"linuxmint"}, true},
+		{[]string{"debian"}, true},
+		{[]string{"fedora", "ubuntu"}, true},
+		{[]string{"fedora", "rhel"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := mint.Is(tt.ids...); got != tt.want {
+			t.Errorf("Is(%q) = %v, want %v", tt.ids, got, tt.want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "os-release")
+	if err := os.WriteFile(path, []byte("ID=debian\nVERSION_ID=\"12\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got.ID != "debian" || got.VersionID != "12" {
+		t.Errorf("Read = %+v", got)
+	}
+	if _, err := Read(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("Read of a missing file = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// TailFile returns up to n last lines of a file, reading at most 64KB
+// 读取文件末尾最多 n 行（最多读取 64KB）
+func TailFile(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	const maxRead = 64 * 1024
+	offset := info.Size() - maxRead
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
+	if offset > 0 && len(lines) > 0 {
+		// The first line is most likely cut in half
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// CopyTree copies the directory src to dst, which must not exist yet,
+// keeping file modes, modification times and symlinks
+// 复制目录树，保留权限、修改时间和符号链接
+func CopyTree(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s 已存在", dst)
+	}
+	// Directory modes and times are set once their contents are in, so a
+	// read-only directory can still be filled and its time is not bumped
+	var dirs []string
+	var infos []os.FileInfo
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.IsDir():
+			dirs, infos = append(dirs, target), append(infos, info)
+			return os.MkdirAll(target, 0700)
+		case info.Mode().IsRegular():
+			if err := copyFile(path, target, info); err != nil {
+				return err
+			}
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		default:
+			// Sockets, devices and fifos are not part of a game install
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i], infos[i].Mode().Perm()); err != nil {
+			return err
+		}
+		os.Chtimes(dirs[i], infos[i].ModTime(), infos[i].ModTime())
+	}
+	return nil
+}
+
+func copyFile(src, dst string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// MoveTree moves src to dst, renaming when both are on the same
+// filesystem and copying then removing src otherwise
+// 移动目录树，同一文件系统内直接重命名，否则复制后删除
+func MoveTree(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := CopyTree(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
@@ -0,0 +1,255 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DurationBuckets suit operations measured in seconds to minutes, such as
+// backups and installs
+// 适合秒到分钟级操作的直方图分桶
+var DurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// LatencyBuckets suit HTTP request latencies in seconds
+// 适合 HTTP 请求延迟的直方图分桶
+var LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ErrLabelCount is returned when an update has more or fewer label values
+// than the family has labels; the update is dropped
+// 标签值数量与注册时不一致
+var ErrLabelCount = errors.New("metrics: 标签值数量不匹配")
+
+// Registry holds metric families and writes them in the Prometheus text
+// exposition format
+// 指标注册表，按 Prometheus 文本格式输出
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	hooks    []func()
+}
+
+// NewRegistry creates an empty Registry
+// 创建注册表
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// OnCollect registers a function run before every WriteText, used to
+// refresh gauges that are read from current state
+// 注册在每次输出前执行的函数，用于刷新实时状态类的指标
+func (r *Registry) OnCollect(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, fn)
+}
+
+// Counter registers a counter family
+// 注册计数器
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", nil, labels)}
+}
+
+// Gauge registers a gauge family
+// 注册仪表
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", nil, labels)}
+}
+
+// Histogram registers a histogram family with the given upper bounds
+// 注册直方图
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r.register(name, help, "histogram", buckets, labels)}
+}
+
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+	return f
+}
+
+// WriteText runs the collect hooks and writes every family
+// 执行刷新函数并输出所有指标
+func (r *Registry) WriteText(w io.Writer) error {
+	// Holding the lock also keeps concurrent scrapes from interleaving
+	// their hooks
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, hook := range r.hooks {
+		hook()
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range r.families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Counter is a family of monotonically increasing values
+// 只增不减的计数器
+type Counter struct{ f *family }
+
+// Inc adds one to the series with the given label values
+func (c *Counter) Inc(values ...string) error {
+	return c.Add(1, values...)
+}
+
+// Add adds v to the series with the given label values
+func (c *Counter) Add(v float64, values ...string) error {
+	return c.f.update(values, func(s *series) { s.value += v })
+}
+
+// Gauge is a family of values that go up and down
+// 可增可减的仪表
+type Gauge struct{ f *family }
+
+// Set sets the series with the given label values
+func (g *Gauge) Set(v float64, values ...string) error {
+	return g.f.update(values, func(s *series) { s.value = v })
+}
+
+// Reset drops every series, so state that is gone stops being reported
+func (g *Gauge) Reset() {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	g.f.series = make(map[string]*series)
+}
+
+// Histogram is a family of observation distributions
+// 直方图
+type Histogram struct{ f *family }
+
+// Observe records v in the series with the given label values
+func (h *Histogram) Observe(v float64, values ...string) error {
+	return h.f.update(values, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.f.buckets))
+		}
+		for i, bound := range h.f.buckets {
+			if v <= bound {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.sum += v
+	})
+}
+
+type family struct {
+	name, help, kind string
+	labels           []string
+	buckets          []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	value  float64
+	// Histograms only; counts are cumulative per bucket
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (f *family) update(values []string, fn func(*series)) error {
+	if len(values) != len(f.labels) {
+		return fmt.Errorf("%w: %s 需要 %d 个，实际为 %d", ErrLabelCount, f.name, len(f.labels), len(values))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	fn(s)
+	return nil
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelText(s.values, "", ""), formatFloat(s.value))
+			continue
+		}
+		for i, bound := range f.buckets {
+			var count uint64
+			if s.counts != nil {
+				count = s.counts[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelText(s.values, "le", formatFloat(bound)), count)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelText(s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelText(s.values, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelText(s.values, "", ""), s.count)
+	}
+}
+
+// labelText renders {a="x",b="y"}, with an optional extra label
+func (f *family) labelText(values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range f.labels {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+escapeLabel(extraValue)+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
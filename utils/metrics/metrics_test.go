@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	tests := []struct {
+		name   string
+		record func(r *Registry)
+		want   string
+	}{
+		{
+			name: "counter without labels",
+			record: func(r *Registry) {
+				c := r.Counter("dst_starts_total", "Starts.")
+				c.Inc()
+				c.Add(2.5)
+			},
+			want: `# HELP dst_starts_total Starts.
+# TYPE dst_starts_total counter
+dst_starts_total 3.5
+`,
+		},
+		{
+			name: "series sorted by label values",
+			record: func(r *Registry) {
+				c := r.Counter("dst_crashes_total", "Crashes.", "cluster", "shard")
+				c.Inc("b", "Master")
+				c.Inc("a", "Caves")
+				c.Inc("a", "Caves")
+			},
+			want: `# HELP dst_crashes_total Crashes.
+# TYPE dst_crashes_total counter
+dst_crashes_total{cluster="a",shard="Caves"} 2
+dst_crashes_total{cluster="b",shard="Master"} 1
+`,
+		},
+		{
+			name: "label and help escaping",
+			record: func(r *Registry) {
+				g := r.Gauge("dst_info", "Back\\slash and\nnewline \"kept\".", "name")
+				g.Set(1, "a \"quoted\" back\\slash\nline")
+			},
+			want: `# HELP dst_info Back\\slash and\nnewline "kept".
+# TYPE dst_info gauge
+dst_info{name="a \"quoted\" back\\slash\nline"} 1
+`,
+		},
+		{
+			name: "gauge special values",
+			record: func(r *Registry) {
+				g := r.Gauge("dst_value", "Values.", "kind")
+				g.Set(math.Inf(1), "inf")
+				g.Set(math.Inf(-1), "minf")
+				g.Set(math.NaN(), "nan")
+				g.Set(1e-7, "small")
+			},
+			want: `# HELP dst_value Values.
+# TYPE dst_value gauge
+dst_value{kind="inf"} +Inf
+dst_value{kind="minf"} -Inf
+dst_value{kind="nan"} NaN
+dst_value{kind="small"} 1e-07
+`,
+		},
+		{
+			name: "gauge reset drops series",
+			record: func(r *Registry) {
+				g := r.Gauge("dst_up", "Up.", "shard")
+				g.Set(1, "Master")
+				g.Reset()
+				g.Set(0, "Caves")
+			},
+			want: `# HELP dst_up Up.
+# TYPE dst_up gauge
+dst_up{shard="Caves"} 0
+`,
+		},
+		{
+			name: "histogram",
+			record: func(r *Registry) {
+				h := r.Histogram("dst_backup_seconds", "Backups.", []float64{1, 5, 10}, "cluster")
+				h.Observe(0.5, "c")
+				h.Observe(5, "c")
+				h.Observe(30, "c")
+			},
+			want: `# HELP dst_backup_seconds Backups.
+# TYPE dst_backup_seconds histogram
+dst_backup_seconds_bucket{cluster="c",le="1"} 1
+dst_backup_seconds_bucket{cluster="c",le="5"} 2
+dst_backup_seconds_bucket{cluster="c",le="10"} 2
+dst_backup_seconds_bucket{cluster="c",le="+Inf"} 3
+dst_backup_seconds_sum{cluster="c"} 35.5
+dst_backup_seconds_count{cluster="c"} 3
+`,
+		},
+		{
+			name: "histogram without labels",
+			record: func(r *Registry) {
+				h := r.Histogram("dst_latency_seconds", "Latency.", []float64{.005, .25})
+				h.Observe(.1)
+			},
+			want: `# HELP dst_latency_seconds Latency.
+# TYPE dst_latency_seconds histogram
+dst_latency_seconds_bucket{le="0.005"} 0
+dst_latency_seconds_bucket{le="0.25"} 1
+dst_latency_seconds_bucket{le="+Inf"} 1
+dst_latency_seconds_sum 0.1
+dst_latency_seconds_count 1
+`,
+		},
+		{
+			name: "families in registration order, empty ones keep their header",
+			record: func(r *Registry) {
+				r.Gauge("dst_b", "B.")
+				r.Counter("dst_a", "A.").Inc()
+			},
+			want: `# HELP dst_b B.
+# TYPE dst_b gauge
+# HELP dst_a A.
+# TYPE dst_a counter
+dst_a 1
+`,
+		},
+		{
+			name: "collect hooks run before writing",
+			record: func(r *Registry) {
+				g := r.Gauge("dst_players", "Players.")
+				r.OnCollect(func() { g.Set(7) })
+			},
+			want: `# HELP dst_players Players.
+# TYPE dst_players gauge
+dst_players 7
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.record(r)
+			var b strings.Builder
+			if err := r.WriteText(&b); err != nil {
+				t.Fatalf("WriteText: %v", err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("WriteText =\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("dst_c", "C.", "cluster")
+	g := r.Gauge("dst_g", "G.", "cluster", "shard")
+	h := r.Histogram("dst_h", "H.", []float64{1}, "method", "route", "status")
+
+	errs := []error{
+		c.Inc(),
+		c.Add(1, "a", "b"),
+		g.Set(1, "a"),
+		h.Observe(1, "GET", "/api"),
+	}
+	for i, err := range errs {
+		if !errors.Is(err, ErrLabelCount) {
+			t.Errorf("update %d: err = %v, want ErrLabelCount", i, err)
+		}
+	}
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if !strings.HasPrefix(line, "# ") {
+			t.Errorf("dropped update was written: %q", line)
+		}
+	}
+}
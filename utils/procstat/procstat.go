@@ -0,0 +1,105 @@
+package procstat
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the CPU times in /proc/<pid>/stat.
+// It is 100 on every Linux architecture the server runs on.
+// /proc/<pid>/stat 中 CPU 时间的单位
+const clockTicks = 100
+
+// Sample is the resource usage of a process at one moment
+// 进程某一时刻的资源占用
+type Sample struct {
+	// CPUTime is the user plus system time used since the process started
+	CPUTime  time.Duration
+	RSSBytes int64
+	Threads  int
+	FDs      int
+}
+
+// Read samples a process from /proc. It fails once the process is gone.
+// 从 /proc 读取进程的资源占用，进程不存在时返回错误
+func Read(pid int) (Sample, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+
+	var sample Sample
+	if err := readStat(filepath.Join(dir, "stat"), &sample); err != nil {
+		return Sample{}, err
+	}
+	rss, err := readRSS(filepath.Join(dir, "status"))
+	if err != nil {
+		return Sample{}, err
+	}
+	sample.RSSBytes = rss
+
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return Sample{}, err
+	}
+	sample.FDs = len(fds)
+	return sample, nil
+}
+
+// readStat reads CPU times and the thread count from /proc/<pid>/stat
+func readStat(path string, sample *Sample) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// The command name is in parentheses and may contain spaces, so the
+	// fields are counted from the last closing parenthesis
+	text := string(data)
+	end := strings.LastIndexByte(text, ')')
+	if end < 0 {
+		return fmt.Errorf("无法解析 %s", path)
+	}
+	// fields[0] is field 3 (state) of proc(5)
+	fields := strings.Fields(text[end+1:])
+	if len(fields) < 18 {
+		return fmt.Errorf("无法解析 %s", path)
+	}
+	utime, err1 := strconv.ParseInt(fields[11], 10, 64)
+	stime, err2 := strconv.ParseInt(fields[12], 10, 64)
+	threads, err3 := strconv.Atoi(fields[17])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return fmt.Errorf("无法解析 %s", path)
+	}
+	sample.CPUTime = time.Duration(utime+stime) * time.Second / clockTicks
+	sample.Threads = threads
+	return nil
+}
+
+// readRSS reads the resident set size from /proc/<pid>/status
+func readRSS(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// VmRSS:	  123456 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "VmRSS:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("无法解析 %s", path)
+			}
+			return kb * 1024, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	// Zombies have no memory left to report
+	return 0, nil
+}
@@ -0,0 +1,325 @@
+package steamcmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultURL is where Valve publishes the Linux SteamCMD bootstrap
+const DefaultURL = "https://steamcdn-a.akamaihd.net/client/installer/steamcmd_linux.tar.gz"
+
+// ErrChecksum is returned when a download does not match the expected
+// checksum
+var ErrChecksum = errors.New("校验和不匹配")
+
+// NewClient returns an HTTP client using proxy, or the proxy from the
+// environment when proxy is empty
+// 创建 HTTP 客户端，未指定代理时使用环境变量中的代理
+func NewClient(proxy string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("无效的代理地址: %q", proxy)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	// No overall timeout, the download may be slow; a stalled connection
+	// is caught by the header timeout and the retries in Download
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	return &http.Client{Transport: transport}, nil
+}
+
+// Download fetches url into dest. Data goes to dest.part first, so an
+// interrupted download continues where it stopped, on the next attempt
+// or the next call, when the server supports ranges. progress, if set, is
+// called as data arrives; total is -1 when unknown.
+// 下载文件到 dest，中断后可以续传
+func Download(client *http.Client, rawURL, dest string, attempts int, progress func(done, total int64)) error {
+	part := dest + ".part"
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * 2 * time.Second)
+		}
+		err = downloadOnce(client, rawURL, part, progress)
+		if err == nil {
+			return os.Rename(part, dest)
+		}
+	}
+	return err
+}
+
+func downloadOnce(client *http.Client, rawURL, part string, progress func(done, total int64)) error {
+	var offset int64
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	total := int64(-1)
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		flags |= os.O_APPEND
+		if resp.ContentLength >= 0 {
+			total = offset + resp.ContentLength
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The part file already holds everything
+		return nil
+	case resp.StatusCode == http.StatusOK:
+		// No range support, start over
+		flags |= os.O_TRUNC
+		offset = 0
+		total = resp.ContentLength
+	default:
+		return fmt.Errorf("下载 %s 失败: %s", rawURL, resp.Status)
+	}
+
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	done := offset
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := f.Write(buf[:n]); err != nil {
+				return err
+			}
+			done += int64(n)
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	if total >= 0 && done != total {
+		return fmt.Errorf("下载不完整: %d / %d 字节", done, total)
+	}
+	return f.Close()
+}
+
+// DownloadVerified is Download followed by a SHA-256 check when want is
+// set; a download that does not match is removed so it is never used
+// 下载并校验 SHA-256，不匹配时删除下载的文件
+func DownloadVerified(client *http.Client, rawURL, dest, want string, attempts int, progress func(done, total int64)) error {
+	if err := Download(client, rawURL, dest, attempts, progress); err != nil {
+		return err
+	}
+	if want == "" {
+		return nil
+	}
+	if err := VerifySHA256(dest, want); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	return nil
+}
+
+// FileSHA256 returns the hex SHA-256 of a file
+// 计算文件的 SHA-256
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifySHA256 checks a file against a hex SHA-256
+// 校验文件的 SHA-256
+func VerifySHA256(path, want string) error {
+	got, err := FileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(got, strings.TrimSpace(want)) {
+		return fmt.Errorf("%w: 期望 %s，实际 %s", ErrChecksum, want, got)
+	}
+	return nil
+}
+
+// ExtractTarGz unpacks a .tar.gz archive into dir. Entries that would end
+// up outside dir are refused.
+// 解压 .tar.gz 到 dir，拒绝解压到目录外的条目
+func ExtractTarGz(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s 不是有效的 gzip 文件: %v", filepath.Base(path), err)
+	}
+	defer gz.Close()
+	return ExtractTar(gz, dir)
+}
+
+// ExtractTar unpacks a tar stream into dir
+// 解压 tar 数据流到 dir
+func ExtractTar(r io.Reader, dir string) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取压缩包失败: %v", err)
+		}
+
+		target, err := entryPath(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tr, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			link := hdr.Linkname
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(target), link)
+			}
+			if !within(root, link) {
+				return fmt.Errorf("压缩包中的链接不安全: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		default:
+			// Devices, fifos and hard links have no place in these archives
+		}
+	}
+}
+
+// ExtractZip unpacks a zip archive, such as a legacy Workshop item, into
+// dir. Entries that would end up outside dir are refused.
+// 解压 zip 到 dir，拒绝解压到目录外的条目
+func ExtractZip(path, dir string) error {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("%s 不是有效的 zip 文件: %v", filepath.Base(path), err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		target, err := entryPath(root, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(target, r, f.Mode().Perm()|0600)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Remove first so a running binary being replaced keeps its inode
+	os.Remove(target)
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// entryPath returns where an archive entry goes under root, refusing
+// absolute names and names that climb out with ..
+func entryPath(root, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return "", fmt.Errorf("压缩包中的路径不安全: %s", name)
+	}
+	target := filepath.Join(root, name)
+	if !within(root, target) {
+		return "", fmt.Errorf("压缩包中的路径不安全: %s", name)
+	}
+	return target, nil
+}
+
+// within reports whether path is root or inside it
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
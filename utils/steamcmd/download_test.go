@@ -0,0 +1,219 @@
+package steamcmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var payload = []byte(strings.Repeat("steamcmd_linux.tar.gz ", 4096))
+
+func TestDownloadResumesWithRange(t *testing.T) {
+	var gotRange string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRange = r.Header.Get("Range")
+		var offset int
+		if _, err := fmt.Sscanf(gotRange, "bytes=%d-", &offset); err != nil {
+			w.Write(payload)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(payload)-1, len(payload)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(payload[offset:])
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(dest+".part", payload[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Download(srv.Client(), srv.URL, dest, 1, nil); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if gotRange != "bytes=1000-" {
+		t.Errorf("Range = %q, want %q", gotRange, "bytes=1000-")
+	}
+	assertFile(t, dest, payload)
+	if _, err := os.Stat(dest + ".part"); !os.IsNotExist(err) {
+		t.Errorf("part file left behind: %v", err)
+	}
+}
+
+func TestDownloadRestartsWithoutRangeSupport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Ignores Range and always sends the whole file
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	dest := filepath.Join(t.TempDir(), "file")
+	// Longer than a plain append would leave intact, and not a prefix
+	if err := os.WriteFile(dest+".part", bytes.Repeat([]byte("x"), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Download(srv.Client(), srv.URL, dest, 1, nil); err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	assertFile(t, dest, payload)
+}
+
+func TestDownloadVerified(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer srv.Close()
+	sum := sha256.Sum256(payload)
+	good := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"no checksum", "", true},
+		{"matching", good, true},
+		{"matching upper case", strings.ToUpper(good), true},
+		{"mismatch", strings.Repeat("0", 64), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "file")
+			err := DownloadVerified(srv.Client(), srv.URL, dest, tt.want, 1, nil)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("DownloadVerified: %v", err)
+				}
+				assertFile(t, dest, payload)
+				return
+			}
+			if !errors.Is(err, ErrChecksum) {
+				t.Fatalf("err = %v, want ErrChecksum", err)
+			}
+			if _, err := os.Stat(dest); !os.IsNotExist(err) {
+				t.Errorf("mismatched download was kept: %v", err)
+			}
+			if _, err := os.Stat(dest + ".part"); !os.IsNotExist(err) {
+				t.Errorf("part file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractTarRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		hdr  tar.Header
+		ok   bool
+	}{
+		{"plain file", tar.Header{Name: "linux32/steamcmd", Typeflag: tar.TypeReg}, true},
+		{"dot dot", tar.Header{Name: "../evil", Typeflag: tar.TypeReg}, false},
+		{"nested dot dot", tar.Header{Name: "a/../../evil", Typeflag: tar.TypeReg}, false},
+		{"absolute", tar.Header{Name: "/tmp/evil", Typeflag: tar.TypeReg}, false},
+		{"absolute dir", tar.Header{Name: "/tmp/evil/", Typeflag: tar.TypeDir}, false},
+		{"symlink inside", tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "linux32/steamcmd"}, true},
+		{"symlink escaping", tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd"}, false},
+		{"symlink absolute", tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			hdr := tt.hdr
+			hdr.Mode = 0755
+			if hdr.Typeflag == tar.TypeReg {
+				hdr.Size = 2
+			}
+			if err := tw.WriteHeader(&hdr); err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				tw.Write([]byte("ok"))
+			}
+			tw.Close()
+
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "root")
+			err := ExtractTar(&buf, dir)
+			if tt.ok && err != nil {
+				t.Fatalf("ExtractTar: %v", err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("ExtractTar accepted an unsafe entry")
+				}
+				if _, err := os.Lstat(filepath.Join(parent, "evil")); !os.IsNotExist(err) {
+					t.Errorf("entry written outside the target: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractZipRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"modinfo.lua", true},
+		{"scripts/modmain.lua", true},
+		{"../evil", false},
+		{"scripts/../../evil", false},
+		{"/tmp/evil", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			archive := filepath.Join(parent, "item.zip")
+			f, err := os.Create(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			zw := zip.NewWriter(f)
+			// CreateHeader keeps the name as given
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: tt.name, Method: zip.Store})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte("ok"))
+			zw.Close()
+			f.Close()
+
+			dir := filepath.Join(parent, "root")
+			err = ExtractZip(archive, dir)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("ExtractZip: %v", err)
+				}
+				assertFile(t, filepath.Join(dir, tt.name), []byte("ok"))
+				return
+			}
+			if err == nil {
+				t.Fatal("ExtractZip accepted an unsafe entry")
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "evil")); !os.IsNotExist(err) {
+				t.Errorf("entry written outside the target: %v", err)
+			}
+		})
+	}
+}
+
+func assertFile(t *testing.T, path string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s has %d bytes, want %d", filepath.Base(path), len(got), len(want))
+	}
+}
@@ -0,0 +1,168 @@
+package steamcmd
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Progress is one "Update state" line of app_update
+// app_update 输出的一行进度
+type Progress struct {
+	// Phase is what SteamCMD is doing: downloading, validating,
+	// preallocating, committing and so on
+	Phase string `json:"phase"`
+	// State is the hex update state SteamCMD prints, such as 0x61
+	State   string  `json:"state"`
+	Percent float64 `json:"percent"`
+	Done    int64   `json:"done"`
+	Total   int64   `json:"total"`
+}
+
+// FailureKind names a known way SteamCMD fails
+// SteamCMD 已知的失败类型
+type FailureKind string
+
+const (
+	FailureMissingConfig  FailureKind = "missing_configuration"
+	FailureDiskFull       FailureKind = "disk_full"
+	FailureDiskWrite      FailureKind = "disk_write"
+	FailureTimeout        FailureKind = "timeout"
+	FailureNetwork        FailureKind = "network"
+	FailureRateLimited    FailureKind = "rate_limited"
+	FailureInvalidBeta    FailureKind = "invalid_beta_password"
+	FailureNoSubscription FailureKind = "no_subscription"
+	// FailureUpdateState is an unfinished update (0x602 and others),
+	// usually fixed by running the update again
+	FailureUpdateState FailureKind = "update_state"
+	FailureUnknown     FailureKind = "unknown"
+)
+
+// Failure is a failing line of SteamCMD output and what it means
+// SteamCMD 输出中的一行错误及其类型
+type Failure struct {
+	Kind FailureKind `json:"kind"`
+	// State is the hex app state of "state is 0x... after update job"
+	State string `json:"state,omitempty"`
+	Line  string `json:"line"`
+}
+
+var (
+	// Update state (0x61) downloading, progress: 45.12 (1234567 / 2736172)
+	progressPattern = regexp.MustCompile(`Update state \((0x[0-9a-fA-F]+)\) ([a-z ]+), progress: ([0-9.]+) \((\d+) / (\d+)\)`)
+	// Error! App '343050' state is 0x202 after update job.
+	statePattern = regexp.MustCompile(`state is (0x[0-9a-fA-F]+) after update job`)
+	// Success. Downloaded item 378160973 to "/x/steamapps/workshop/content/322330/378160973" (123 bytes)
+	itemSuccessPattern = regexp.MustCompile(`Downloaded item (\d+) to "([^"]+)"`)
+	// ERROR! Download item 378160973 failed (Timeout).
+	itemFailurePattern = regexp.MustCompile(`Download item (\d+) failed`)
+)
+
+// stateFailures maps the app states SteamCMD fails with to their kind.
+// The state is a set of appmanifest StateFlags bits.
+var stateFailures = map[string]FailureKind{
+	"0x202": FailureDiskFull,
+	"0x402": FailureNetwork,
+	"0x602": FailureUpdateState,
+	"0x6":   FailureUpdateState,
+}
+
+// failurePatterns are matched case-insensitively, first match wins
+var failurePatterns = []struct {
+	text string
+	kind FailureKind
+}{
+	{"missing configuration", FailureMissingConfig},
+	{"not enough disk space", FailureDiskFull},
+	{"no space left on device", FailureDiskFull},
+	{"disk write failure", FailureDiskWrite},
+	{"invalid password", FailureInvalidBeta},
+	{"no subscription", FailureNoSubscription},
+	{"rate limit exceeded", FailureRateLimited},
+	{"timeout", FailureTimeout},
+	{"timed out", FailureTimeout},
+	{"no connection", FailureNetwork},
+	{"connection failed", FailureNetwork},
+	{"service unavailable", FailureNetwork},
+}
+
+// ParseProgress reads an "Update state" line
+// 解析进度行
+func ParseProgress(line string) (Progress, bool) {
+	match := progressPattern.FindStringSubmatch(line)
+	if match == nil {
+		return Progress{}, false
+	}
+	p := Progress{State: match[1], Phase: strings.TrimSpace(match[2])}
+	p.Percent, _ = strconv.ParseFloat(match[3], 64)
+	p.Done, _ = strconv.ParseInt(match[4], 10, 64)
+	p.Total, _ = strconv.ParseInt(match[5], 10, 64)
+	return p, true
+}
+
+// ParseFailure recognises a line reporting that the install failed
+// 识别表示安装失败的输出行
+func ParseFailure(line string) (Failure, bool) {
+	if match := statePattern.FindStringSubmatch(line); match != nil {
+		kind, ok := stateFailures[strings.ToLower(match[1])]
+		if !ok {
+			kind = FailureUpdateState
+		}
+		return Failure{Kind: kind, State: match[1], Line: line}, true
+	}
+	lower := strings.ToLower(strings.TrimSpace(line))
+	// Only "ERROR! ..." and "...FAILED (reason)" lines; SteamCMD prints
+	// harmless failures such as "Failed to init SDL priority manager" on
+	// every run
+	if !strings.HasPrefix(lower, "error!") && !strings.Contains(lower, "failed (") {
+		return Failure{}, false
+	}
+	for _, p := range failurePatterns {
+		if strings.Contains(lower, p.text) {
+			return Failure{Kind: p.kind, Line: line}, true
+		}
+	}
+	return Failure{Kind: FailureUnknown, Line: line}, true
+}
+
+// WorkshopItem is the outcome of one workshop_download_item
+// 下载一个创意工坊物品的结果
+type WorkshopItem struct {
+	ID string `json:"id"`
+	// Path is where SteamCMD put the item
+	Path    string   `json:"path,omitempty"`
+	Failure *Failure `json:"failure,omitempty"`
+}
+
+// ParseWorkshopItem reads the line workshop_download_item ends with
+// 解析创意工坊物品下载结果的输出行
+func ParseWorkshopItem(line string) (WorkshopItem, bool) {
+	if match := itemSuccessPattern.FindStringSubmatch(line); match != nil {
+		return WorkshopItem{ID: match[1], Path: match[2]}, true
+	}
+	if match := itemFailurePattern.FindStringSubmatch(line); match != nil {
+		failure, ok := ParseFailure(line)
+		if !ok {
+			failure = Failure{Kind: FailureUnknown, Line: line}
+		}
+		return WorkshopItem{ID: match[1], Failure: &failure}, true
+	}
+	return WorkshopItem{}, false
+}
+
+// ScanLines splits SteamCMD output on \n and on the \r it uses to redraw
+// progress lines
+// 按 \n 和 \r 拆分 SteamCMD 的输出
+func ScanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
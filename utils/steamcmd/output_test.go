@@ -0,0 +1,127 @@
+package steamcmd
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		line string
+		want Progress
+		ok   bool
+	}{
+		{
+			" Update state (0x61) downloading, progress: 45.12 (1234567 / 2736172)",
+			Progress{Phase: "downloading", State: "0x61", Percent: 45.12, Done: 1234567, Total: 2736172},
+			true,
+		},
+		{
+			"Update state (0x101) committing, progress: 100.00 (10 / 10)",
+			Progress{Phase: "committing", State: "0x101", Percent: 100, Done: 10, Total: 10},
+			true,
+		},
+		{"Success! App '343050' fully installed.", Progress{}, false},
+		{"", Progress{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseProgress(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseProgress(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseFailure(t *testing.T) {
+	tests := []struct {
+		line  string
+		kind  FailureKind
+		state string
+		ok    bool
+	}{
+		{"Error! App '343050' state is 0x202 after update job.", FailureDiskFull, "0x202", true},
+		{"Error! App '343050' state is 0x402 after update job.", FailureNetwork, "0x402", true},
+		{"Error! App '343050' state is 0x602 after update job.", FailureUpdateState, "0x602", true},
+		{"Error! App '343050' state is 0x6 after update job.", FailureUpdateState, "0x6", true},
+		{"Error! App '343050' state is 0x1234 after update job.", FailureUpdateState, "0x1234", true},
+		{"ERROR! Failed to install app '343050' (Missing configuration)", FailureMissingConfig, "", true},
+		{"ERROR! Failed to install app '343050' (No subscription)", FailureNoSubscription, "", true},
+		{"ERROR! Failed to install app '343050' (Invalid Password)", FailureInvalidBeta, "", true},
+		{"ERROR! Failed to install app '343050' (Disk write failure)", FailureDiskWrite, "", true},
+		{"ERROR! Download item 378160973 failed (Timeout).", FailureTimeout, "", true},
+		{"ERROR! Download item 378160973 failed (Rate Limit Exceeded).", FailureRateLimited, "", true},
+		{"ERROR! Something nobody has seen before", FailureUnknown, "", true},
+		{"Login Failure: Service Unavailable", "", "", false},
+		{"Failed to init SDL priority manager: SDL not found", "", "", false},
+		{"Success! App '343050' fully installed.", "", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseFailure(tt.line)
+		if ok != tt.ok {
+			t.Errorf("ParseFailure(%q) ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got.Kind != tt.kind || got.State != tt.state || got.Line != tt.line {
+			t.Errorf("ParseFailure(%q) = %+v, want kind %q state %q", tt.line, got, tt.kind, tt.state)
+		}
+	}
+}
+
+func TestParseWorkshopItem(t *testing.T) {
+	tests := []struct {
+		line string
+		want WorkshopItem
+		ok   bool
+	}{
+		{
+			`Success. Downloaded item 378160973 to "/x/steamapps/workshop/content/322330/378160973" (123 bytes)`,
+			WorkshopItem{ID: "378160973", Path: "/x/steamapps/workshop/content/322330/378160973"},
+			true,
+		},
+		{
+			"ERROR! Download item 378160973 failed (Timeout).",
+			WorkshopItem{ID: "378160973", Failure: &Failure{Kind: FailureTimeout, Line: "ERROR! Download item 378160973 failed (Timeout)."}},
+			true,
+		},
+		{
+			"ERROR! Download item 1 failed (Failure).",
+			WorkshopItem{ID: "1", Failure: &Failure{Kind: FailureUnknown, Line: "ERROR! Download item 1 failed (Failure)."}},
+			true,
+		},
+		{"Downloading item 378160973 ...", WorkshopItem{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseWorkshopItem(tt.line)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWorkshopItem(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"a\nb\n", []string{"a", "b"}},
+		{"a\rb\rc", []string{"a", "b", "c"}},
+		{"a\r\nb", []string{"a", "", "b"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		s := bufio.NewScanner(strings.NewReader(tt.in))
+		s.Split(ScanLines)
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ScanLines(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
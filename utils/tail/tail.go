@@ -0,0 +1,211 @@
+package tail
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often the file is checked for new data; a variable
+// so tests can poll faster
+// 检查文件新内容的间隔
+var pollInterval = 500 * time.Millisecond
+
+// Line is one complete line of the followed file
+// 文件中的一行
+type Line struct {
+	// Offset is the byte offset where the line starts
+	Offset int64 `json:"offset"`
+	// Next is the offset right after the line, used to resume
+	Next int64 `json:"next"`
+	// Number is the 1-based line number
+	Number int64  `json:"line"`
+	Text   string `json:"text"`
+}
+
+// Position tells Follow where to start. Offset wins over Line; with
+// neither set Follow starts at the end of the file.
+// 开始读取的位置：优先按字节偏移，其次按行号，都没有时从文件末尾开始
+type Position struct {
+	Offset int64
+	Line   int64
+	// FromStart reads the whole file when no offset or line is given
+	FromStart bool
+}
+
+// Follow streams the lines of a file as they are written, like tail -F.
+// It keeps following across truncation (the file starts over) and
+// rotation (the path points to a new file), and waits for the file to
+// appear if it does not exist yet. The channel closes when ctx is done.
+// 像 tail -F 一样持续读取文件新行，文件被截断或轮转后会从新文件开头继续
+func Follow(ctx context.Context, path string, from Position) <-chan Line {
+	out := make(chan Line, 64)
+	go func() {
+		defer close(out)
+		f := &follower{path: path, out: out}
+		f.run(ctx, from)
+	}()
+	return out
+}
+
+type follower struct {
+	path   string
+	out    chan<- Line
+	file   *os.File
+	reader *bufio.Reader
+	offset int64
+	number int64
+	// partial holds a line whose newline has not been written yet
+	partial string
+}
+
+func (f *follower) run(ctx context.Context, from Position) {
+	defer f.close()
+
+	// The start position only applies to the file as it is now; a file
+	// that appears later is new and read from its beginning
+	if f.open() == nil {
+		f.seek(from)
+	}
+	for {
+		if f.file == nil {
+			f.open()
+		}
+
+		if f.file != nil {
+			if !f.drain(ctx) {
+				return
+			}
+		}
+		if f.file != nil {
+			f.checkReplaced()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (f *follower) open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.reader = bufio.NewReader(file)
+	f.offset = 0
+	f.number = 0
+	f.partial = ""
+	return nil
+}
+
+func (f *follower) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// seek moves to the requested start position, counting lines on the way
+// so that line numbers stay correct
+func (f *follower) seek(from Position) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return
+	}
+	size := info.Size()
+
+	switch {
+	case from.Offset > 0:
+		// An offset past the end means the file was truncated since
+		if from.Offset > size {
+			return
+		}
+		f.skipWhile(func() bool { return f.offset < from.Offset })
+	case from.Line > 0:
+		f.skipWhile(func() bool { return f.number < from.Line })
+	case !from.FromStart:
+		f.skipWhile(func() bool { return f.offset < size })
+	}
+}
+
+// skipWhile consumes complete lines without sending them
+func (f *follower) skipWhile(cond func() bool) {
+	for cond() {
+		text, err := f.reader.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete tail for the next read
+			f.partial = text
+			f.offset += int64(len(text))
+			return
+		}
+		f.offset += int64(len(text))
+		f.number++
+	}
+}
+
+// drain sends every complete line that is available; it returns false
+// when ctx is done
+func (f *follower) drain(ctx context.Context) bool {
+	for {
+		text, err := f.reader.ReadString('\n')
+		if err != nil {
+			f.partial += text
+			f.offset += int64(len(text))
+			if err != io.EOF {
+				f.close()
+			}
+			return true
+		}
+
+		start := f.offset - int64(len(f.partial))
+		f.offset += int64(len(text))
+		text = f.partial + text
+		f.partial = ""
+		f.number++
+
+		line := Line{
+			Offset: start,
+			Next:   f.offset,
+			Number: f.number,
+			Text:   strings.TrimRight(text, "\r\n"),
+		}
+
+		select {
+		case f.out <- line:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// checkReplaced reopens the file after truncation or rotation
+func (f *follower) checkReplaced() {
+	current, err := f.file.Stat()
+	if err != nil {
+		return
+	}
+	onDisk, err := os.Stat(f.path)
+	if err != nil {
+		// Rotated away and not recreated yet; keep the old file for now
+		return
+	}
+
+	if !os.SameFile(current, onDisk) {
+		// Rotated: the old file has been drained, switch to the new one
+		f.close()
+		f.open()
+		return
+	}
+	if onDisk.Size() < f.offset {
+		// Truncated in place: start over from the beginning
+		f.close()
+		f.open()
+	}
+}
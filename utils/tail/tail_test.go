@@ -0,0 +1,190 @@
+package tail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	pollInterval = 20 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+func TestFollow(t *testing.T) {
+	tests := []struct {
+		name string
+		// initial is written before Follow starts; nil means no file yet
+		initial *string
+		from    Position
+		want    []Line
+		// then changes the file after want has arrived
+		then      func(t *testing.T, path string)
+		wantAfter []Line
+	}{
+		{
+			name:    "from start",
+			initial: str("a\nb\r\n"),
+			from:    Position{FromStart: true},
+			want:    []Line{{0, 2, 1, "a"}, {2, 5, 2, "b"}},
+		},
+		{
+			name:      "from end",
+			initial:   str("a\nb\n"),
+			then:      appendTo("c\n"),
+			wantAfter: []Line{{4, 6, 3, "c"}},
+		},
+		{
+			name:      "resume from offset",
+			initial:   str("a\nb\nc\n"),
+			from:      Position{Offset: 2},
+			want:      []Line{{2, 4, 2, "b"}, {4, 6, 3, "c"}},
+			then:      appendTo("d\n"),
+			wantAfter: []Line{{6, 8, 4, "d"}},
+		},
+		{
+			name:    "resume from line",
+			initial: str("a\nb\nc\n"),
+			from:    Position{Line: 2},
+			want:    []Line{{4, 6, 3, "c"}},
+		},
+		{
+			name:    "offset wins over line",
+			initial: str("a\nb\nc\n"),
+			from:    Position{Offset: 4, Line: 1},
+			want:    []Line{{4, 6, 3, "c"}},
+		},
+		{
+			name:    "offset past the end starts over",
+			initial: str("a\n"),
+			from:    Position{Offset: 100},
+			want:    []Line{{0, 2, 1, "a"}},
+		},
+		{
+			name:      "partial line waits for its newline",
+			initial:   str("a\nb"),
+			from:      Position{FromStart: true},
+			want:      []Line{{0, 2, 1, "a"}},
+			then:      appendTo("c\n"),
+			wantAfter: []Line{{2, 5, 2, "bc"}},
+		},
+		{
+			name:      "partial line at the end when starting from the end",
+			initial:   str("a\nb"),
+			then:      appendTo("c\nd\n"),
+			wantAfter: []Line{{2, 5, 2, "bc"}, {5, 7, 3, "d"}},
+		},
+		{
+			name:    "truncated in place",
+			initial: str("aaa\nbbb\n"),
+			from:    Position{FromStart: true},
+			want:    []Line{{0, 4, 1, "aaa"}, {4, 8, 2, "bbb"}},
+			then: func(t *testing.T, path string) {
+				write(t, path, "x\n")
+			},
+			wantAfter: []Line{{0, 2, 1, "x"}},
+		},
+		{
+			name:    "rotated",
+			initial: str("a\n"),
+			from:    Position{FromStart: true},
+			want:    []Line{{0, 2, 1, "a"}},
+			then: func(t *testing.T, path string) {
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+				write(t, path, "new\n")
+			},
+			wantAfter: []Line{{0, 4, 1, "new"}},
+		},
+		{
+			name:      "file appears later",
+			from:      Position{Offset: 2},
+			then:      appendTo("a\nb\n"),
+			wantAfter: []Line{{0, 2, 1, "a"}, {2, 4, 2, "b"}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "server_log.txt")
+			if tt.initial != nil {
+				write(t, path, *tt.initial)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			lines := Follow(ctx, path, tt.from)
+			expect(t, lines, tt.want)
+			if tt.then != nil {
+				// Let the follower reach the end of what is there
+				time.Sleep(2 * pollInterval)
+				tt.then(t, path)
+				expect(t, lines, tt.wantAfter)
+			}
+			select {
+			case line := <-lines:
+				t.Errorf("unexpected line %+v", line)
+			case <-time.After(2 * pollInterval):
+			}
+		})
+	}
+}
+
+func TestFollowClosesOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := Follow(ctx, filepath.Join(t.TempDir(), "missing"), Position{})
+	cancel()
+	select {
+	case _, ok := <-lines:
+		if ok {
+			t.Error("got a line from a missing file")
+		}
+	case <-time.After(4 * pollInterval):
+		t.Error("channel not closed after cancel")
+	}
+}
+
+func expect(t *testing.T, lines <-chan Line, want []Line) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got, ok := <-lines:
+			if !ok {
+				t.Fatalf("channel closed, want %+v", w)
+			}
+			if got != w {
+				t.Errorf("got %+v, want %+v", got, w)
+			}
+		case <-time.After(50 * pollInterval):
+			t.Fatalf("timed out waiting for %+v", w)
+		}
+	}
+}
+
+func appendTo(text string) func(t *testing.T, path string) {
+	return func(t *testing.T, path string) {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func write(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func str(s string) *string {
+	return &s
+}
@@ -0,0 +1,332 @@
+package vdf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Node is one KeyValues entry. A node either holds a string Value or,
+// when Children is not nil, a section of nested entries. Order and
+// duplicate keys are kept as they were read.
+// KeyValues 节点：要么是字符串值，要么是包含子节点的段落
+type Node struct {
+	Key      string
+	Value    string
+	Children []*Node
+}
+
+// NewSection creates an empty section node
+// 创建空段落
+func NewSection(key string) *Node {
+	return &Node{Key: key, Children: []*Node{}}
+}
+
+// IsSection reports whether the node holds nested entries
+// 是否为段落
+func (n *Node) IsSection() bool {
+	return n.Children != nil
+}
+
+// Get follows a path of keys, matched case-insensitively like Steam does,
+// and returns nil when any of them is missing
+// 按键路径查找子节点（不区分大小写），找不到时返回 nil
+func (n *Node) Get(path ...string) *Node {
+	current := n
+	for _, key := range path {
+		if current == nil {
+			return nil
+		}
+		var found *Node
+		for _, child := range current.Children {
+			if strings.EqualFold(child.Key, key) {
+				found = child
+				break
+			}
+		}
+		current = found
+	}
+	return current
+}
+
+// String returns the value at a path, or "" when it is missing or a section
+// 返回路径上的字符串值，不存在或为段落时返回空字符串
+func (n *Node) String(path ...string) string {
+	if node := n.Get(path...); node != nil && !node.IsSection() {
+		return node.Value
+	}
+	return ""
+}
+
+// Set stores a value under key, replacing the first entry with that key
+// 设置键值，已存在时替换第一个同名项
+func (n *Node) Set(key, value string) {
+	if child := n.Get(key); child != nil {
+		child.Value, child.Children = value, nil
+		return
+	}
+	n.Children = append(n.Children, &Node{Key: key, Value: value})
+}
+
+// Section returns the section under key, creating it when missing
+// 返回指定段落，不存在时创建
+func (n *Node) Section(key string) *Node {
+	if child := n.Get(key); child != nil && child.IsSection() {
+		return child
+	}
+	child := NewSection(key)
+	n.Children = append(n.Children, child)
+	return child
+}
+
+// Parse reads every top level entry of a KeyValues document and returns
+// them as the children of an unnamed root section
+// 解析整个 KeyValues 文档，顶层条目作为无名根段落的子节点返回
+func Parse(r io.Reader) (*Node, error) {
+	root := NewSection("")
+	d := NewDecoder(r)
+	for {
+		node, err := d.Next()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		root.Children = append(root.Children, node)
+	}
+}
+
+// Decoder reads top level entries one at a time, so a document can be
+// read from a stream that continues with other text, like SteamCMD output
+// 逐个读取顶层条目，适合读取后面还跟着其他内容的输出
+type Decoder struct {
+	r    *bufio.Reader
+	line int
+}
+
+// NewDecoder creates a Decoder reading from r
+// 创建解码器
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), line: 1}
+}
+
+// Next returns the next top level entry, or io.EOF when there is none
+// 读取下一个顶层条目，没有时返回 io.EOF
+func (d *Decoder) Next() (*Node, error) {
+	tok, err := d.token()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind != tokString {
+		return nil, d.errorf("需要键名，遇到了 %q", tok.text)
+	}
+	return d.entry(tok.text)
+}
+
+// entry reads the value of key: a string or a braced section
+func (d *Decoder) entry(key string) (*Node, error) {
+	tok, err := d.token()
+	if err == io.EOF {
+		return nil, d.errorf("%q 缺少值", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok.kind {
+	case tokString:
+		return &Node{Key: key, Value: tok.text}, nil
+	case tokOpen:
+		section := NewSection(key)
+		for {
+			tok, err := d.token()
+			if err == io.EOF {
+				return nil, d.errorf("段落 %q 缺少 }", key)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if tok.kind == tokClose {
+				return section, nil
+			}
+			if tok.kind != tokString {
+				return nil, d.errorf("需要键名，遇到了 %q", tok.text)
+			}
+			child, err := d.entry(tok.text)
+			if err != nil {
+				return nil, err
+			}
+			section.Children = append(section.Children, child)
+		}
+	default:
+		return nil, d.errorf("%q 的值不能是 %q", key, tok.text)
+	}
+}
+
+type tokenKind int
+
+const (
+	tokString tokenKind = iota
+	tokOpen
+	tokClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// token returns the next string or brace, skipping whitespace, comments
+// and [$PLATFORM] conditionals
+func (d *Decoder) token() (token, error) {
+	for {
+		c, err := d.read()
+		if err != nil {
+			return token{}, err
+		}
+		switch {
+		case c == '\n' || c == ' ' || c == '\t' || c == '\r':
+		case c == '{':
+			return token{tokOpen, "{"}, nil
+		case c == '}':
+			return token{tokClose, "}"}, nil
+		case c == '"':
+			return d.quoted()
+		case c == '/' && d.peek() == '/':
+			if err := d.skipLine(); err != nil {
+				return token{}, err
+			}
+		case c == '[':
+			// Conditionals only matter to the Steam client
+			if err := d.skipUntil(']'); err != nil {
+				return token{}, err
+			}
+		default:
+			return d.bare(c)
+		}
+	}
+}
+
+func (d *Decoder) quoted() (token, error) {
+	var b strings.Builder
+	for {
+		c, err := d.read()
+		if err == io.EOF {
+			return token{}, d.errorf("字符串缺少结尾的引号")
+		}
+		if err != nil {
+			return token{}, err
+		}
+		switch c {
+		case '"':
+			return token{tokString, b.String()}, nil
+		case '\\':
+			next, err := d.read()
+			if err != nil {
+				return token{}, d.errorf("字符串缺少结尾的引号")
+			}
+			switch next {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				// \\ and \" and anything unknown keep the character
+				b.WriteByte(next)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (d *Decoder) bare(first byte) (token, error) {
+	b := []byte{first}
+	for {
+		c := d.peek()
+		if c == 0 || c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '{' || c == '}' || c == '"' {
+			return token{tokString, string(b)}, nil
+		}
+		d.read()
+		b = append(b, c)
+	}
+}
+
+func (d *Decoder) read() (byte, error) {
+	c, err := d.r.ReadByte()
+	if c == '\n' {
+		d.line++
+	}
+	return c, err
+}
+
+// peek returns the next byte without consuming it, or 0 at the end
+func (d *Decoder) peek() byte {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *Decoder) skipLine() error {
+	return d.skipUntil('\n')
+}
+
+func (d *Decoder) skipUntil(end byte) error {
+	for {
+		c, err := d.read()
+		if err == io.EOF && end == '\n' {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c == end {
+			return nil
+		}
+	}
+}
+
+func (d *Decoder) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("vdf 第 %d 行: %s", d.line, fmt.Sprintf(format, a...))
+}
+
+// ErrNotSection is returned by Write for a root node that holds a value
+var ErrNotSection = errors.New("vdf: 只能写出段落")
+
+// Write writes the children of root as a KeyValues document, in the
+// layout Steam uses for its own files
+// 按 Steam 的格式写出根段落的子节点
+func Write(w io.Writer, root *Node) error {
+	if !root.IsSection() {
+		return ErrNotSection
+	}
+	bw := bufio.NewWriter(w)
+	for _, child := range root.Children {
+		writeNode(bw, child, 0)
+	}
+	return bw.Flush()
+}
+
+func writeNode(w *bufio.Writer, n *Node, depth int) {
+	indent := strings.Repeat("\t", depth)
+	if !n.IsSection() {
+		fmt.Fprintf(w, "%s%s\t\t%s\n", indent, quote(n.Key), quote(n.Value))
+		return
+	}
+	fmt.Fprintf(w, "%s%s\n%s{\n", indent, quote(n.Key), indent)
+	for _, child := range n.Children {
+		writeNode(w, child, depth+1)
+	}
+	fmt.Fprintf(w, "%s}\n", indent)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+
+func quote(s string) string {
+	return `"` + escaper.Replace(s) + `"`
+}
@@ -0,0 +1,191 @@
+package vdf
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []*Node
+	}{
+		{
+			"value",
+			`"key" "value"`,
+			[]*Node{{Key: "key", Value: "value"}},
+		},
+		{
+			"bare tokens",
+			"key value\nother 2",
+			[]*Node{{Key: "key", Value: "value"}, {Key: "other", Value: "2"}},
+		},
+		{
+			"nested sections",
+			`"AppState" { "appid" "343050" "UserConfig" { "betakey" "public" } }`,
+			[]*Node{{Key: "AppState", Children: []*Node{
+				{Key: "appid", Value: "343050"},
+				{Key: "UserConfig", Children: []*Node{{Key: "betakey", Value: "public"}}},
+			}}},
+		},
+		{
+			"empty section",
+			`"x" {}`,
+			[]*Node{{Key: "x", Children: []*Node{}}},
+		},
+		{
+			"escapes",
+			`"k" "a\"b\\c\nd\te\q"`,
+			[]*Node{{Key: "k", Value: "a\"b\\c\nd\te" + "q"}},
+		},
+		{
+			"comments and conditionals",
+			"// header\n\"a\" \"1\" // trailing\n\"b\" \"2\" [$WIN32]\n// end",
+			[]*Node{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}},
+		},
+		{
+			"duplicate keys kept in order",
+			`"s" { "k" "1" "k" "2" }`,
+			[]*Node{{Key: "s", Children: []*Node{{Key: "k", Value: "1"}, {Key: "k", Value: "2"}}}},
+		},
+		{
+			"empty document",
+			"  \n// nothing\n",
+			[]*Node{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := Parse(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(root.Children, tt.want) {
+				t.Errorf("Parse(%q) = %s, want %s", tt.in, dump(root.Children), dump(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"missing value", `"key"`},
+		{"unterminated string", `"key" "value`},
+		{"unterminated section", `"s" { "k" "v"`},
+		{"stray close", `}`},
+		{"section as key", `"s" { { } }`},
+		{"close as value", `"k" }`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.in)); err == nil {
+				t.Errorf("Parse(%q) succeeded", tt.in)
+			}
+		})
+	}
+}
+
+func TestDecoderStopsAfterEntry(t *testing.T) {
+	d := NewDecoder(strings.NewReader("\"343050\"\n{\n\t\"common\" { \"name\" \"Don't Starve Together Dedicated Server\" }\n}\nSteam> "))
+	node, err := d.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if got := node.String("common", "name"); got != "Don't Starve Together Dedicated Server" {
+		t.Errorf("name = %q", got)
+	}
+	// What follows is not KeyValues, only a bare key with no value
+	if _, err := d.Next(); err == nil || err == io.EOF {
+		t.Errorf("second Next = %v, want a parse error", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	root, err := Parse(strings.NewReader(`"AppState" { "AppID" "343050" "UserConfig" { "BetaKey" "beta" } }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path []string
+		want string
+	}{
+		{[]string{"appstate", "appid"}, "343050"},
+		{[]string{"AppState", "userconfig", "BETAKEY"}, "beta"},
+		{[]string{"AppState", "UserConfig"}, ""},
+		{[]string{"AppState", "missing", "deeper"}, ""},
+		{[]string{"nothing"}, ""},
+	}
+	for _, tt := range tests {
+		if got := root.String(tt.path...); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if root.Get("AppState", "missing", "deeper") != nil {
+		t.Error("Get on a missing path returned a node")
+	}
+}
+
+func TestSetAndSection(t *testing.T) {
+	root := NewSection("")
+	s := root.Section("AppState")
+	s.Set("appid", "343050")
+	s.Set("AppID", "322330")
+	if again := root.Section("appstate"); again != s {
+		t.Error("Section created a second section for the same key")
+	}
+	if len(s.Children) != 1 || s.String("appid") != "322330" {
+		t.Errorf("Set did not replace the value: %s", dump(s.Children))
+	}
+	// Setting a value over a section turns it into a value
+	root.Set("AppState", "gone")
+	if root.Get("AppState").IsSection() {
+		t.Error("Set left a section in place")
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	in := `"AppState" { "appid" "343050" "name" "a \"quoted\" \\ path" "UserConfig" { } "InstalledDepots" { "343052" { "manifest" "1" } } }`
+	root, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, root); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := "\"AppState\"\n{\n" +
+		"\t\"appid\"\t\t\"343050\"\n" +
+		"\t\"name\"\t\t\"a \\\"quoted\\\" \\\\ path\"\n" +
+		"\t\"UserConfig\"\n\t{\n\t}\n" +
+		"\t\"InstalledDepots\"\n\t{\n\t\t\"343052\"\n\t\t{\n\t\t\t\"manifest\"\t\t\"1\"\n\t\t}\n\t}\n" +
+		"}\n"
+	if buf.String() != want {
+		t.Errorf("Write =\n%s\nwant\n%s", buf.String(), want)
+	}
+	again, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse after Write: %v", err)
+	}
+	if !reflect.DeepEqual(again, root) {
+		t.Errorf("round trip changed the document: %s", dump(again.Children))
+	}
+}
+
+func TestWriteRejectsValue(t *testing.T) {
+	if err := Write(io.Discard, &Node{Key: "k", Value: "v"}); err != ErrNotSection {
+		t.Errorf("Write = %v, want ErrNotSection", err)
+	}
+}
+
+func dump(nodes []*Node) string {
+	var buf bytes.Buffer
+	Write(&buf, &Node{Children: append([]*Node{}, nodes...)})
+	return buf.String()
+}